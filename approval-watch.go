@@ -0,0 +1,222 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultApprovalWarnDays is how many days before the expiry
+// an approval is reported as expiring.
+const defaultApprovalWarnDays = 30
+
+// ApprovalIssue is a TLD reported by CheckApprovals.
+type ApprovalIssue struct {
+	TLD string
+
+	// RequestID and Status are of the latest access request of
+	// the TLD; blank if the account has never requested the TLD.
+	RequestID string
+	Status    AccessRequestStatus
+
+	// Expires is the expiry of the approval (if approved).
+	Expires time.Time
+
+	// Err is set if the extension of the approval failed.
+	Err error
+}
+
+// ApprovalReport is the result of CheckApprovals.
+type ApprovalReport struct {
+	// Missing are the TLDs that have dropped out of the download-links;
+	// i.e. named in ApprovedTLD (or selected in the links of the previous
+	// check) but not in the current links.
+	Missing []ApprovalIssue
+
+	// Expiring are the approvals that expire within ApprovalWarnDays.
+	Expiring []ApprovalIssue
+
+	// Denied are the TLDs whose latest request was denied,
+	// revoked, or has expired.
+	Denied []ApprovalIssue
+
+	// Extended are the approvals whose extension was requested
+	// (AutoExtendApprovals).
+	Extended []ApprovalIssue
+}
+
+// CheckApprovals compares the approved TLDs with the download-links
+// and the access requests of the account; the issues are returned and
+// also sent to the observers. Expiring approvals are extended if
+// AutoExtendApprovals is set.
+func (c *CzdsAPI) CheckApprovals() (ApprovalReport, error) {
+
+	dlinks, err := c.getDownloadLinks()
+	if err != nil {
+		return ApprovalReport{}, err
+	}
+
+	return c.checkApprovals(dlinks)
+}
+
+// checkApprovals is CheckApprovals with the download-links
+// already fetched.
+func (c *CzdsAPI) checkApprovals(dlinks []string) (ApprovalReport, error) {
+
+	var report ApprovalReport
+
+	requests, err := c.ListAccessRequests(AccessRequestFilter{})
+	if err != nil {
+		return report, err
+	}
+	latest := latestAccessRequests(requests)
+
+	linkTLDs := make(map[string]bool)
+	for _, link := range dlinks {
+		linkTLDs[getTLDFromDownloadLink(link)] = true
+	}
+
+	// the TLDs to check: the ones named in ApprovedTLD, and
+	// the selected TLDs of the current and the previous links.
+	watched := make(map[string]bool)
+	for _, p := range c.icann.ApprovedTLD {
+		if !isGlobPattern(p) {
+			watched[p] = true
+		}
+	}
+	for _, m := range []map[string]bool{c.icann.linkTLDs, linkTLDs} {
+		for tld := range m {
+			if c.icann.tldSelected(tld) {
+				watched[tld] = true
+			}
+		}
+	}
+	c.icann.linkTLDs = linkTLDs
+
+	warnBefore := time.Duration(c.icann.ApprovalWarnDays) * 24 * time.Hour
+	if warnBefore <= 0 {
+		warnBefore = defaultApprovalWarnDays * 24 * time.Hour
+	}
+
+	tlds := make([]string, 0, len(watched))
+	for tld := range watched {
+		tlds = append(tlds, tld)
+	}
+	sort.Strings(tlds)
+
+	for _, tld := range tlds {
+
+		issue := ApprovalIssue{TLD: tld}
+		r, requested := latest[tld]
+		if requested {
+			issue.RequestID = r.RequestID
+			issue.Status = r.Status
+			issue.Expires = r.Expired.Time
+
+			// CZDS may not have updated the status yet
+			if issue.Status == AccessRequestApproved && !issue.Expires.IsZero() && issue.Expires.Before(time.Now()) {
+				issue.Status = AccessRequestExpired
+			}
+		}
+
+		if !linkTLDs[tld] {
+			report.Missing = append(report.Missing, issue)
+		}
+
+		switch issue.Status {
+		case AccessRequestDenied, AccessRequestRevoked, AccessRequestExpired:
+			report.Denied = append(report.Denied, issue)
+
+		case AccessRequestApproved:
+			if issue.Expires.IsZero() || time.Until(issue.Expires) > warnBefore {
+				continue
+			}
+			if c.icann.AutoExtendApprovals {
+				extended, err := c.extendApproval(issue.RequestID)
+				if extended {
+					report.Extended = append(report.Extended, issue)
+					continue
+				}
+				issue.Err = err
+			}
+			report.Expiring = append(report.Expiring, issue)
+		}
+	}
+
+	c.emitApprovalReport(report)
+
+	return report, nil
+}
+
+// extendApproval requests the extension of an approval; false
+// is returned (with a nil error) if CZDS does not allow it yet, or
+// the extension has already been requested.
+func (c *CzdsAPI) extendApproval(requestID string) (bool, error) {
+
+	d, err := c.GetAccessRequest(requestID)
+	if err != nil {
+		return false, err
+	}
+	if !d.Extensible || d.ExtensionInProcess {
+		return false, nil
+	}
+
+	if err := c.ExtendAccessRequest(requestID); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// emitApprovalReport sends an event for each issue of
+// the report, and an EventApprovalsChecked.
+func (c *CzdsAPI) emitApprovalReport(report ApprovalReport) {
+
+	send := func(t EventType, list []ApprovalIssue) {
+		for _, v := range list {
+			c.icann.emit(Event{Type: t, TLD: v.TLD, RequestID: v.RequestID,
+				RequestStatus: string(v.Status), Expires: v.Expires, Err: v.Err})
+		}
+	}
+
+	send(EventApprovalMissing, report.Missing)
+	send(EventApprovalExpiring, report.Expiring)
+	send(EventApprovalDenied, report.Denied)
+	send(EventApprovalExtended, report.Extended)
+
+	c.icann.emit(Event{Type: EventApprovalsChecked, Missing: len(report.Missing),
+		Expiring: len(report.Expiring), Denied: len(report.Denied)})
+}
+
+// latestAccessRequests returns the request that decides the status
+// of each TLD; the approved request with the latest expiry, or
+// else the newest request.
+func latestAccessRequests(requests []AccessRequest) map[string]AccessRequest {
+
+	m := make(map[string]AccessRequest)
+
+	for _, r := range requests {
+		tld := strings.ToLower(r.TLD)
+		cur, ok := m[tld]
+		switch {
+		case !ok:
+			m[tld] = r
+		case r.Status == AccessRequestApproved:
+			if cur.Status != AccessRequestApproved || r.Expired.After(cur.Expired.Time) {
+				m[tld] = r
+			}
+		case cur.Status != AccessRequestApproved && r.Created.After(cur.Created.Time):
+			m[tld] = r
+		}
+	}
+
+	return m
+}
+
+// getTLDFromDownloadLink returns the TLD of a download-link
+// e.g. https://czds-api.icann.org/czds/downloads/com.zone => com.
+func getTLDFromDownloadLink(link string) string {
+	v := strings.Split(link, "/")
+	return strings.ToLower(strings.TrimSuffix(v[len(v)-1], ".zone"))
+}
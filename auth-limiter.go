@@ -0,0 +1,279 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAuthAttempts and defaultAuthWindow are ICANN's limit
+	// of 8 authentication attempts in 5 minutes per IP address.
+	defaultAuthAttempts = 8
+	defaultAuthWindow   = 5 * time.Minute
+
+	// authBackoffMin and authBackoffMax bound the backoff
+	// after failed attempts (status 0, 4xx or 5xx).
+	authBackoffMin = 5 * time.Second
+	authBackoffMax = 5 * time.Minute
+
+	// staleLockAge is when a lock file is deemed left
+	// behind (by a process that has died).
+	staleLockAge = 30 * time.Second
+)
+
+// defaultAuthLimiter is shared by the clients of this process
+// that have no AuthLimiter of their own.
+var defaultAuthLimiter = NewAuthLimiter("")
+
+// AuthLimiter paces the authentication attempts; it allows Attempts in
+// a (sliding) Window, honors Retry-After on 429, and backs off (with
+// jitter) after the attempts that failed (status 0, 4xx or 5xx).
+// Clients that share one share the limit. With a Path, the attempts
+// are kept in a file; so that processes on the same host (or behind
+// one NAT, with a shared volume) share the limit too.
+type AuthLimiter struct {
+	Attempts int           // default is 8
+	Window   time.Duration // default is 5 minutes
+
+	// Path is the state file (optional); it is locked (Path.lock)
+	// while it is read or written.
+	Path string
+
+	mu    sync.Mutex
+	state authLimiterState
+}
+
+// authLimiterState is what an AuthLimiter keeps (in
+// memory, or in the state file).
+type authLimiterState struct {
+	Attempts  []time.Time `json:"attempts"`
+	NotBefore time.Time   `json:"notBefore"` // Retry-After, or the backoff
+	Failures  int         `json:"failures"`  // in a row, without a 2xx
+}
+
+// NewAuthLimiter returns a limiter with ICANN's limit; path is
+// the state file to share with other processes (blank for none).
+func NewAuthLimiter(path string) *AuthLimiter {
+	return &AuthLimiter{Attempts: defaultAuthAttempts, Window: defaultAuthWindow, Path: path}
+}
+
+// Wait blocks until an attempt is allowed, and takes it.
+func (l *AuthLimiter) Wait(ctx context.Context) error {
+	for {
+		d, err := l.reserve(ctx)
+		if err != nil || d <= 0 {
+			return err
+		}
+		if err := sleepContext(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes an attempt and returns zero; or returns how
+// long to wait for the next one.
+func (l *AuthLimiter) reserve(ctx context.Context) (time.Duration, error) {
+
+	var d time.Duration
+
+	err := l.update(ctx, func(st *authLimiterState, now time.Time) bool {
+
+		attempts, window := l.limit()
+
+		// the attempts in the window
+		i := 0
+		for i < len(st.Attempts) && !st.Attempts[i].After(now.Add(-window)) {
+			i++
+		}
+		st.Attempts = st.Attempts[i:]
+
+		d = st.NotBefore.Sub(now)
+		if len(st.Attempts) >= attempts {
+			if w := st.Attempts[len(st.Attempts)-attempts].Add(window).Sub(now); w > d {
+				d = w
+			}
+		}
+		if d > 0 {
+			return false
+		}
+
+		st.Attempts = append(st.Attempts, now)
+		return true
+	})
+
+	return d, err
+}
+
+// Done reports the result of an attempt: statusCode of the response
+// (zero for none), and its headers (for Retry-After). Any status other
+// than 2xx and 429 backs off; not only 0 and 5xx, as a 4xx (e.g. 401
+// on a bad password) fails the same way when it is tried again.
+func (l *AuthLimiter) Done(ctx context.Context, statusCode int, hd http.Header) error {
+
+	return l.update(ctx, func(st *authLimiterState, now time.Time) bool {
+
+		switch {
+		case statusCode == http.StatusTooManyRequests:
+			d := retryAfter(hd, now)
+			if d <= 0 {
+				_, d = l.limit()
+			}
+			st.NotBefore = now.Add(d)
+
+		case statusCode >= 200 && statusCode < 300:
+			st.Failures = 0
+			st.NotBefore = time.Time{}
+
+		default:
+			// no valid response (0 or 5xx), or rejected (4xx);
+			// retrying right away won't help.
+			st.Failures++
+			st.NotBefore = now.Add(authBackoff(st.Failures))
+		}
+
+		return true
+	})
+}
+
+func (l *AuthLimiter) limit() (int, time.Duration) {
+	attempts, window := l.Attempts, l.Window
+	if attempts <= 0 {
+		attempts = defaultAuthAttempts
+	}
+	if window <= 0 {
+		window = defaultAuthWindow
+	}
+	return attempts, window
+}
+
+// update calls fn with the state; it is saved if fn returns
+// true. The state file (if any) is locked meanwhile.
+func (l *AuthLimiter) update(ctx context.Context, fn func(st *authLimiterState, now time.Time) bool) error {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.Path == "" {
+		fn(&l.state, time.Now())
+		return nil
+	}
+
+	unlock, err := lockFile(ctx, l.Path+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var st authLimiterState
+	b, err := os.ReadFile(l.Path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(b) > 0 {
+		// a damaged file is started over
+		json.Unmarshal(b, &st)
+	}
+
+	if !fn(&st, time.Now()) {
+		return nil
+	}
+
+	b, _ = json.Marshal(st)
+
+	return writeFileAtomic(l.Path, b)
+}
+
+// lockFile creates the lock file at path (O_EXCL); it waits while
+// another process holds it. A lock file older than staleLockAge is
+// removed.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+
+		if err := sleepContext(ctx, 50*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeFileAtomic writes b to a temp file (0600), and
+// renames it to path.
+func writeFileAtomic(path string, b []byte) error {
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	return err
+}
+
+// retryAfter returns the Retry-After (seconds, or a date)
+// of hd; zero if there is none.
+func retryAfter(hd http.Header, now time.Time) time.Duration {
+
+	v := hd.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now)
+	}
+
+	return 0
+}
+
+// authBackoff returns the backoff after n failures in a row; it
+// doubles from authBackoffMin up to authBackoffMax, and half of
+// it is random (jitter), so that clients do not retry together.
+func authBackoff(n int) time.Duration {
+
+	d := authBackoffMin
+	for i := 1; i < n && d < authBackoffMax; i++ {
+		d *= 2
+	}
+	if d > authBackoffMax {
+		d = authBackoffMax
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,150 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reserveN takes n attempts; each must be allowed.
+func reserveN(t *testing.T, l *AuthLimiter, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		if d, err := l.reserve(context.Background()); err != nil || d > 0 {
+			t.Fatalf("attempt %d: wait %v, %v; want none", i+1, d, err)
+		}
+	}
+}
+
+// Attempts are allowed in a sliding window; the next one waits
+// for the first to leave it.
+func TestAuthLimiterWindow(t *testing.T) {
+
+	l := &AuthLimiter{Attempts: 3, Window: 300 * time.Millisecond}
+	reserveN(t, l, 3)
+
+	d, err := l.reserve(context.Background())
+	if err != nil || d <= 0 || d > 300*time.Millisecond {
+		t.Fatalf("the 4th attempt: wait %v, %v; want up to the window", d, err)
+	}
+
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < d-10*time.Millisecond {
+		t.Errorf("Wait returned after %v; want %v", waited, d)
+	}
+
+	// the wait ends with the context
+	l = &AuthLimiter{Attempts: 1, Window: time.Minute}
+	reserveN(t, l, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait = %v; want context.DeadlineExceeded", err)
+	}
+}
+
+func TestAuthLimiterRetryAfter(t *testing.T) {
+
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name     string
+		hd       http.Header
+		min, max time.Duration
+	}{
+		{"seconds", http.Header{"Retry-After": {"120"}}, 119 * time.Second, 120 * time.Second},
+		{"date", http.Header{"Retry-After": {now.Add(time.Hour).UTC().Format(http.TimeFormat)}}, 58 * time.Minute, time.Hour},
+		// the window, without a Retry-After
+		{"none", nil, 4 * time.Minute, 5 * time.Minute},
+	} {
+		l := NewAuthLimiter("")
+		reserveN(t, l, 1)
+		if err := l.Done(ctx, http.StatusTooManyRequests, tc.hd); err != nil {
+			t.Fatal(err)
+		}
+		d, _ := l.reserve(ctx)
+		if d < tc.min || d > tc.max {
+			t.Errorf("%s: wait %v; want %v-%v", tc.name, d, tc.min, tc.max)
+		}
+	}
+
+	if d := retryAfter(http.Header{"Retry-After": {"soon"}}, now); d != 0 {
+		t.Errorf("retryAfter of a bad value = %v; want 0", d)
+	}
+}
+
+// A failed attempt (0, 4xx or 5xx) backs off, and more so with each
+// failure in a row; a 2xx ends the backoff.
+func TestAuthLimiterBackoff(t *testing.T) {
+
+	ctx := context.Background()
+	l := &AuthLimiter{Attempts: 100, Window: time.Minute}
+
+	for i, status := range []int{0, http.StatusServiceUnavailable, http.StatusUnauthorized} {
+		l.Done(ctx, status, nil)
+
+		want := authBackoffMin << i
+		d, _ := l.reserve(ctx)
+		if d < want/2-time.Second || d > want {
+			t.Errorf("after %d failures (%d): wait %v; want %v-%v", i+1, status, d, want/2, want)
+		}
+	}
+
+	l.Done(ctx, http.StatusOK, nil)
+	reserveN(t, l, 1)
+
+	for n := 1; n < 20; n++ {
+		if d := authBackoff(n); d < authBackoffMin/2 || d > authBackoffMax {
+			t.Errorf("authBackoff(%d) = %v", n, d)
+		}
+	}
+}
+
+// Limiters with the same Path (i.e. in other processes) share the
+// attempts; the lock file is removed after each update.
+func TestAuthLimiterFile(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "auth-limiter.json")
+
+	l1 := &AuthLimiter{Attempts: 2, Window: time.Minute, Path: path}
+	l2 := &AuthLimiter{Attempts: 2, Window: time.Minute, Path: path}
+
+	reserveN(t, l1, 1)
+	reserveN(t, l2, 1)
+	if d, err := l1.reserve(ctx); err != nil || d <= 0 {
+		t.Errorf("the 3rd attempt: wait %v, %v; want the window", d, err)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("the lock file is left behind: %v", err)
+	}
+
+	// a damaged file is started over
+	os.WriteFile(path, []byte("{"), 0600)
+	reserveN(t, l2, 2)
+
+	// a lock that is held
+	os.WriteFile(path+".lock", nil, 0600)
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := l1.reserve(tctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("reserve with a held lock = %v; want context.DeadlineExceeded", err)
+	}
+
+	// a lock that is left behind
+	old := time.Now().Add(-2 * staleLockAge)
+	os.Chtimes(path+".lock", old, old)
+	if _, err := l1.reserve(ctx); err != nil {
+		t.Errorf("reserve with a stale lock = %v", err)
+	}
+}
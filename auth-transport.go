@@ -0,0 +1,105 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// authTransport is the http.RoundTripper of the CZDS calls. It adds
+// the bearer token; and if the server rejects the token (401, or 403
+// with WWW-Authenticate invalid_token) it renews the token once and replays the request.
+// A 403 for a TLD is passed on as is.
+type authTransport struct {
+	icann *IcannAPI
+	base  http.RoundTripper
+}
+
+// czdsClient returns the http client of the CZDS calls; it
+// is the HTTPClient (or a default) with an authTransport.
+func (i *IcannAPI) czdsClient() *http.Client {
+
+	c := *i.getHTTPClient()
+
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c.Transport = &authTransport{icann: i, base: base}
+
+	return &c
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	ctx := req.Context()
+
+	tok, err := t.icann.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := req.Clone(ctx)
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tok.Token))
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || !tokenRejected(resp) {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// the body cannot be sent again
+		return resp, nil
+	}
+
+	t.icann.logger().Info("access token rejected; renewing", "url", req.URL.String(), "status_code", resp.StatusCode)
+
+	// renew waits for the auth-attempt timeout (if any)
+	tok, err = t.icann.tokens.renew(ctx, tok)
+	if err != nil {
+		// the caller gets the rejected response
+		return resp, nil
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	r = req.Clone(ctx)
+	if req.GetBody != nil {
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tok.Token))
+
+	return t.base.RoundTrip(r)
+}
+
+func (t *authTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// tokenRejected returns true if resp is a rejection of the access
+// token: a 401, or a 403 that is about the token (rather than a TLD).
+func tokenRejected(resp *http.Response) bool {
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return true
+
+	case http.StatusForbidden:
+		return tokenError(resp.Header)
+	}
+
+	return false
+}
+
+// tokenError returns true if a 403 response says that the token is
+// invalid or expired; i.e. WWW-Authenticate: Bearer error="invalid_token"
+// (RFC 6750). The body is not looked at; a 403 for a TLD may well
+// mention a token too.
+func tokenError(hd http.Header) bool {
+	return strings.Contains(hd.Get("WWW-Authenticate"), "invalid_token")
+}
@@ -0,0 +1,189 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kambahr/go-icann-api-client/czdstest"
+)
+
+// testConfig returns a Config that points at s (user u/p); each
+// test has its own zone-file dir, token cache and auth limiter.
+func testConfig(t *testing.T, s *czdstest.Server) Config {
+	s.AddUser("u", "p")
+
+	return Config{
+		UserAgent:            "icannclient-test / 1.0",
+		IcannAccountUserName: "u",
+		IcannAccountPassword: "p",
+		ZoneFileDir:          t.TempDir(),
+		TokenCache:           NewMemoryTokenCache(),
+		AuthLimiter:          NewAuthLimiter(""),
+		Logger:               NewLogger(io.Discard, "", 0),
+		AccountBaseURL:       s.URL,
+		CzdsBaseURL:          s.URL,
+	}
+}
+
+// forbiddenServer is a CZDS server that responds with a 403 (with hd
+// and body) to the first n requests; and then with 200 and a link.
+type forbiddenServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	n        int
+	requests int
+}
+
+func newForbiddenServer(t *testing.T, n int, hd http.Header, body string) *forbiddenServer {
+
+	fs := &forbiddenServer{n: n}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		fs.requests++
+		forbid := fs.requests <= fs.n
+		fs.mu.Unlock()
+
+		if forbid {
+			for k, v := range hd {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, body)
+			return
+		}
+		io.WriteString(w, `["`+fs.URL+`/czds/downloads/com.zone"]`)
+	}))
+	t.Cleanup(fs.Close)
+
+	return fs
+}
+
+func (fs *forbiddenServer) Requests() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.requests
+}
+
+// newForbiddenClient returns a client that authenticates with s,
+// and makes the CZDS calls to fs.
+func newForbiddenClient(t *testing.T, s *czdstest.Server, fs *forbiddenServer) *CzdsAPI {
+
+	cnf := testConfig(t, s)
+	cnf.CzdsBaseURL = fs.URL
+
+	icn, err := New(context.Background(), cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return icn.CzdsAPI.(*CzdsAPI)
+}
+
+var invalidToken = http.Header{"Www-Authenticate": {`Bearer error="invalid_token", error_description="The access token expired"`}}
+
+// A 403 with WWW-Authenticate invalid_token is about the
+// token; it is renewed and the request is sent again.
+func TestForbiddenInvalidTokenIsReplayed(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	fs := newForbiddenServer(t, 1, invalidToken, `{"message":"Forbidden"}`)
+	c := newForbiddenClient(t, s, fs)
+
+	links, err := c.getDownloadLinks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 {
+		t.Errorf("links = %v", links)
+	}
+	if n := fs.Requests(); n != 2 {
+		t.Errorf("requests = %d; want 2", n)
+	}
+	if n := s.AuthAttempts(); n != 2 {
+		t.Errorf("auth attempts = %d; want 2 (New and the renewal)", n)
+	}
+}
+
+// A token that is rejected again after the renewal is a *TokenError.
+func TestForbiddenInvalidTokenAfterRenewal(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	fs := newForbiddenServer(t, 2, invalidToken, `{"message":"Forbidden"}`)
+	c := newForbiddenClient(t, s, fs)
+
+	_, err := c.getZoneFileStatus(fs.URL + "/czds/downloads/com.zone")
+
+	var te *TokenError
+	if !errors.As(err, &te) || te.StatusCode != http.StatusForbidden {
+		t.Fatalf("err = %v; want a 403 *TokenError", err)
+	}
+	if n := fs.Requests(); n != 2 {
+		t.Errorf("requests = %d; want 2", n)
+	}
+}
+
+// A 403 of a download link is about the TLD (even if the body
+// mentions a token); the token is left alone.
+func TestForbiddenTLDIsZoneAccessError(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	fs := newForbiddenServer(t, 1, nil, `{"message":"Your token does not grant access to this zone file"}`)
+	c := newForbiddenClient(t, s, fs)
+
+	// a GET; the body of a HEAD is not sent
+	link := fs.URL + "/czds/downloads/com.zone"
+	res := c.icann.czdsExec(GET, link, nil)
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("status = %d; want 403", res.StatusCode)
+	}
+	err := czdsError("DownloadZoneFile", link, res.StatusCode, res.ResponseHeaders, res.ResponseBody)
+
+	var ze *ZoneAccessError
+	if !errors.As(err, &ze) || ze.TLD != "com" || ze.StatusCode != http.StatusForbidden {
+		t.Fatalf("err = %v; want a 403 *ZoneAccessError for com", err)
+	}
+	if n := fs.Requests(); n != 1 {
+		t.Errorf("requests = %d; want 1", n)
+	}
+	if n := s.AuthAttempts(); n != 1 {
+		t.Errorf("auth attempts = %d; want 1", n)
+	}
+}
+
+// Any other 403 (not of a download link) is an *APIError;
+// the token is left alone.
+func TestForbiddenLinksIsAPIError(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	fs := newForbiddenServer(t, 1, nil, `{"message":"token holder has not accepted the terms"}`)
+	c := newForbiddenClient(t, s, fs)
+
+	_, err := c.getDownloadLinks()
+
+	var ae *APIError
+	if !errors.As(err, &ae) || ae.StatusCode != http.StatusForbidden {
+		t.Fatalf("err = %v; want a 403 *APIError", err)
+	}
+	if n := fs.Requests(); n != 1 {
+		t.Errorf("requests = %d; want 1", n)
+	}
+	if n := s.AuthAttempts(); n != 1 {
+		t.Errorf("auth attempts = %d; want 1", n)
+	}
+}
@@ -2,128 +2,609 @@
 package icannclient
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path"
-	"runtime"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
-
-	"golang.org/x/text/language"
-	"golang.org/x/text/message"
 )
 
 // Write keeps track of the number of bytes
-// written + the elapsed time; and writes the status
-// to the console.
+// written + the elapsed time; and sends the status
+// to the observers (EventDownloadProgress) about
+// once a second.
 func (wm *TeeWriter) Write(p []byte) (int, error) {
 
-	// for formatting the downloaded bytes
-	mp := message.NewPrinter(language.English)
-
 	n := len(p)
 	wm.TotalDownloaded += uint64(n)
 
-	d := time.Since(wm.StartTime)
+	if wm.icann == nil || time.Since(wm.lastReport) < progressInterval {
+		return n, nil
+	}
+	wm.lastReport = time.Now()
 
-	fmt.Printf("\r\t%s %v mb elapsed: %v", wm.TLDType, mp.Sprintf("%d", wm.TotalDownloaded/1024), formatDuration(d))
+	wm.icann.emit(wm.event(EventDownloadProgress))
 
 	return n, nil
 }
 
-// DownloadZoneFile downloads a zone file from an assigned link.
+// event returns an event with the status of the download.
+func (wm *TeeWriter) event(t EventType) Event {
+
+	d := time.Since(wm.StartTime)
+	e := Event{Type: t, TLD: wm.TLDType, URL: wm.URL, LocalFilePath: wm.FileName,
+		Bytes: wm.TotalDownloaded, TotalBytes: wm.TotalExpected, Elapsed: d}
+
+	// the rate of this session; resumed bytes are not counted.
+	if sec := d.Seconds(); sec > 0 {
+		e.BytesPerSecond = float64(wm.TotalDownloaded-wm.startOffset) / sec
+	}
+	if e.BytesPerSecond > 0 && wm.TotalExpected > wm.TotalDownloaded {
+		e.ETA = time.Duration(float64(wm.TotalExpected-wm.TotalDownloaded) / e.BytesPerSecond * float64(time.Second))
+	}
+
+	return e
+}
+
+// DownloadZoneFile downloads a zone file from an assigned link. The
+// attempt (and its result) is recorded in the download state.
 func (c *CzdsAPI) DownloadZoneFile(localFilePath string, downloadLink string, wg *sync.WaitGroup) (int, error) {
 
 	if wg != nil {
 		defer wg.Done()
 	}
 
-	ConsoleClearLastLine()
-	fmt.Println("download Link:", downloadLink)
-
-	// download will be skipped, if the local file exists.
+	// download will be skipped, if the local file is complete.
 	// note: in case of partial download (i.e. computer shutdown
-	// or network drop), the bad file has to be removed manually.
+	// or network drop), the bytes are kept in the .part file
+	// and the download is resumed on the next attempt.
 	// This is important to keep up with the once-in-24
 	// hour download agreement.
-	if FileOrDirExists(localFilePath) {
-		errTxt := fmt.Sprintf("%s already exist", localFilePath)
-		fmt.Println(errTxt)
-		return -1, errors.New(errTxt)
-	}
-
-	headers := c.icann.GetCommonHeaders()
-	headers.Add("Authorization", fmt.Sprintf("Bearer %s", c.icann.AccessToken.Token))
-
-	// see if there is enough disk-space before
-	// downloading the file. In case there is not
-	// enough, keep waiting
-	fs := c.getZoneFileStatus(downloadLink)
-	if runtime.GOOS == linux {
-		fileSizeGig := float64(fs.FileLength) / 1024.0 / 1024.0 / 1024.0
-		allowedGB := getMaxFreeDiskForZoneFile()
-		for {
-			ok := fileSizeGig < allowedGB
-			if ok {
-				break
-			} else {
-				fmt.Println("")
-				log.Printf("\rnot enough disk-space, please, free some disk-space to continue")
-				time.Sleep(time.Minute)
-			}
+	complete, err := c.zoneFileComplete(localFilePath, downloadLink)
+	if err != nil {
+		return -1, err
+	}
+	if complete {
+		return -1, nil
+	}
+
+	// an incomplete file (e.g. truncated by an older
+	// version); it is downloaded again.
+	store, name := c.zoneStoreFor(localFilePath)
+	if _, err := store.Stat(c.icann.context(), name); err == nil {
+		if err := store.Delete(c.icann.context(), name); err != nil {
+			return -1, err
 		}
+		store.Delete(c.icann.context(), name+sha256FileExt)
+	}
+
+	rec := c.beginDownloadRecord(localFilePath, downloadLink)
+
+	statusCode, err := c.downloadZoneFile(localFilePath, downloadLink, &rec)
+
+	c.finishDownloadRecord(&rec, statusCode, err)
+
+	return statusCode, err
+}
+
+// downloadZoneFile does the download; rec is updated with what
+// is learned on the way (size, validators, bytes, digest).
+func (c *CzdsAPI) downloadZoneFile(localFilePath string, downloadLink string, rec *DownloadRecord) (int, error) {
+
+	fs, err := c.getZoneFileStatus(downloadLink)
+	if err != nil {
+		return errorStatusCode(err), err
+	}
+	rec.ContentLength = int64(fs.FileLength)
+	rec.ETag = fs.ETag
+	rec.LastModified = fs.LastModified
+
+	// a zone file that has not changed since the last download
+	// is linked to the new date; it is not downloaded again.
+	prev, hasPrev := c.previousZoneFile(*rec)
+	if hasPrev && prev.sameVersion(fs) {
+		return -1, c.linkUnchangedZoneFile(prev, rec)
 	}
 
+	store, name := c.zoneStoreFor(localFilePath)
+
 	fileName := path.Base(localFilePath)
-	txtToDisplay := fmt.Sprintf("downloading '%s' as '%s'", fs.OriginalFileName, fileName)
 
-	ConsoleClearLastLine()
-	log.Println(txtToDisplay)
+	// only the local store keeps a partial file; the other
+	// stores get the download as a stream.
+	ls, isLocal := store.(*LocalZoneStore)
+
+	// the partial file has a fixed name so that an interrupted
+	// download can be resumed (by this session, the retry loop,
+	// or after a restart).
+	var tempFilePath string
+	var offset int64
+
+	// the digest covers the bytes of the previous attempts
+	hash := sha256.New()
+
+	if isLocal {
+		tempFilePath = getPartFilePath(ls.path(name))
+		offset = c.getResumeOffset(tempFilePath, fs)
+		if offset > 0 {
+			if err := hashFile(hash, tempFilePath, offset); err != nil {
+				return -1, err
+			}
+		}
 
-	tmName := fmt.Sprintf("_%s.part", time.Now().String()[48:])
-	tempFilePath := fmt.Sprintf("%s%s", localFilePath, tmName)
+		if offset > 0 && fs.FileLength > 0 && uint64(offset) == fs.FileLength {
+			// all bytes are already on disk
+			rec.BytesReceived = offset
+			rec.SHA256 = hex.EncodeToString(hash.Sum(nil))
+			return -1, completeZoneFile(ls, tempFilePath, name, fs, rec.SHA256, false)
+		}
+	}
 
-	if FileOrDirExists(tempFilePath) {
-		os.Remove(tempFilePath)
+	// see if there is enough disk-space for the rest of the
+	// file before downloading it; in case there is not enough,
+	// wait (up to DiskSpaceWait) for some space to be freed.
+	if err := c.waitForSpace(store, name, int64(fs.FileLength)-offset, c.icann.DiskSpaceWait); err != nil {
+		return -1, err
 	}
 
-	ioOutput, err := os.Create(tempFilePath)
+	req, err := http.NewRequestWithContext(c.icann.context(), http.MethodGet, downloadLink, nil)
 	if err != nil {
 		return -1, err
 	}
+	req.Header = c.icann.GetCommonHeaders()
+
+	if offset == 0 && hasPrev {
+		// (in case HEAD did not tell)
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
+	if offset > 0 {
+		// If-Range makes the server send the whole (new) file with a 200,
+		// should the zone file change since the partial download started.
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", fs.validator())
+	}
 
-	client := &http.Client{}
-	req, _ := http.NewRequest(http.MethodGet, downloadLink, nil)
-	req.Header = headers
-	resp, err := client.Do(req)
+	resp, err := c.icann.czdsClient().Do(req)
 	if err != nil {
-		ioOutput.Close()
 		return -1, err
 	}
 
 	defer resp.Body.Close()
 
+	var flag int
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if getContentRangeStart(resp.Header) != offset {
+			return resp.StatusCode, fmt.Errorf("%s: unexpected Content-Range: %s", downloadLink, resp.Header.Get("Content-Range"))
+		}
+		flag = os.O_WRONLY | os.O_APPEND
+
+	case http.StatusOK:
+		// a full response; either a new download or the
+		// zone file has changed since the partial download.
+		offset = 0
+		hash.Reset()
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	case http.StatusNotModified:
+		if !hasPrev {
+			return resp.StatusCode, fmt.Errorf("%s: unexpected status-code 304", downloadLink)
+		}
+		return resp.StatusCode, c.linkUnchangedZoneFile(prev, rec)
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file does not fit the remote file; start over
+		// on the next attempt.
+		if isLocal {
+			removePartFile(tempFilePath)
+		}
+		return resp.StatusCode, fmt.Errorf("%s: range not satisfiable; partial download discarded", downloadLink)
+
+	default:
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, czdsError("DownloadZoneFile", downloadLink, resp.StatusCode, resp.Header, b)
+	}
+
+	if !isLocal {
+		return resp.StatusCode, c.streamZoneFile(store, name, resp.Body, fs, rec, hash)
+	}
+
+	// save the validator of this response; it will be
+	// sent with the If-Range header when resuming.
+	if err = writePartInfo(tempFilePath, partFileInfo{DownloadURL: downloadLink,
+		ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified"),
+		FileLength: fs.FileLength}); err != nil {
+		return -1, err
+	}
+
+	ioOutput, err := os.OpenFile(tempFilePath, flag, 0644)
+	if err != nil {
+		return -1, err
+	}
+
 	// Initialize the tee-writer.
-	teeWriter := &TeeWriter{File: ioOutput, TempFilePath: tempFilePath,
-		FileName: fileName, TLDType: fs.TLDType, StartTime: time.Now()}
+	teeWriter := &TeeWriter{File: ioOutput, TempFilePath: tempFilePath, TotalDownloaded: uint64(offset),
+		FileName: fileName, TLDType: fs.TLDType, StartTime: time.Now(), URL: downloadLink,
+		TotalExpected: fs.FileLength, startOffset: uint64(offset), icann: c.icann}
+
+	c.icann.emit(teeWriter.event(EventDownloadStarted))
 
-	if _, err = io.Copy(ioOutput, io.TeeReader(resp.Body, teeWriter)); err != nil {
+	// a download from the start is verified on the way; a resumed one
+	// is verified (from the disk) once complete, as the first part
+	// was not seen.
+	var body io.Reader = io.TeeReader(resp.Body, io.MultiWriter(teeWriter, hash))
+	verified := offset == 0
+	if verified {
+		vr := newVerifyingReader(body, tempFilePath, fs.TLDType, fs.FileLength)
+		defer vr.Close()
+		body = vr
+	}
+
+	_, err = io.Copy(ioOutput, body)
+	rec.BytesReceived = int64(teeWriter.TotalDownloaded)
+	if err != nil {
 		ioOutput.Close()
+		discardPartFile(tempFilePath, fs, err)
 		return resp.StatusCode, err
 	}
+	rec.SHA256 = hex.EncodeToString(hash.Sum(nil))
 
 	// Close the file, before renaming it.
-	ioOutput.Close()
+	if err = ioOutput.Close(); err != nil {
+		return resp.StatusCode, err
+	}
 
-	if err = os.Rename(tempFilePath, localFilePath); err != nil {
+	if err = completeZoneFile(ls, tempFilePath, name, fs, rec.SHA256, verified); err != nil {
 		return resp.StatusCode, err
 	}
 
-	fmt.Println("")
+	c.icann.emit(teeWriter.event(EventDownloadCompleted))
 
 	return -1, nil
 }
+
+// streamZoneFile puts the body of a download into a store (that is
+// not local); the zone file is verified on the way and is not created
+// if a check fails. The download is not resumable.
+func (c *CzdsAPI) streamZoneFile(store ZoneStore, name string, body io.Reader, fs ZoneFileStatus, rec *DownloadRecord, h hash.Hash) error {
+
+	teeWriter := &TeeWriter{FileName: name, TLDType: fs.TLDType, StartTime: time.Now(), URL: rec.URL,
+		TotalExpected: fs.FileLength, icann: c.icann}
+
+	c.icann.emit(teeWriter.event(EventDownloadStarted))
+
+	vr := newVerifyingReader(io.TeeReader(body, io.MultiWriter(teeWriter, h)), name, fs.TLDType, fs.FileLength)
+	defer vr.Close()
+
+	err := store.Put(c.icann.context(), name, vr)
+	rec.BytesReceived = int64(teeWriter.TotalDownloaded)
+	if err != nil {
+		return err
+	}
+	rec.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	if err := putSHA256(c.icann.context(), store, name, rec.SHA256); err != nil {
+		return err
+	}
+
+	c.icann.emit(teeWriter.event(EventDownloadCompleted))
+
+	return nil
+}
+
+// beginDownloadRecord records the start of an attempt.
+func (c *CzdsAPI) beginDownloadRecord(localFilePath string, downloadLink string) DownloadRecord {
+
+	date, tld := getZoneFileDate(localFilePath), getTLDFromDownloadLink(downloadLink)
+
+	rec, _ := c.icann.store.get(tld, date)
+	rec.TLD, rec.Date = tld, date
+	rec.URL = downloadLink
+	rec.LocalFilePath = localFilePath
+	rec.Status = DownloadInProgress
+	rec.Attempts++
+	rec.StatusCode = 0
+	rec.SHA256 = ""
+	rec.UnchangedSince = ""
+	rec.Started = time.Now()
+	rec.Finished = time.Time{}
+
+	if err := c.icann.store.put(rec); err != nil {
+		c.icann.logger().Error("unable to save the download state", "tld", tld, "error", err)
+	}
+
+	return rec
+}
+
+// finishDownloadRecord records the result of an attempt.
+func (c *CzdsAPI) finishDownloadRecord(rec *DownloadRecord, statusCode int, err error) {
+
+	rec.Finished = time.Now()
+	if statusCode > 0 {
+		rec.StatusCode = statusCode
+	}
+
+	if err == nil {
+		rec.Status = DownloadCompleted
+		rec.LastError = ""
+	} else {
+		rec.Status = DownloadFailed
+		var zae *ZoneAccessError
+		if errors.As(err, &zae) {
+			rec.Status = DownloadDenied
+		}
+		rec.LastError = err.Error()
+		rec.SHA256 = ""
+		rec.BytesReceived = 0
+		if fi, serr := os.Stat(getPartFilePath(rec.LocalFilePath)); serr == nil {
+			rec.BytesReceived = fi.Size()
+		}
+	}
+
+	if err := c.icann.store.put(*rec); err != nil {
+		c.icann.logger().Error("unable to save the download state", "tld", rec.TLD, "error", err)
+	}
+}
+
+// previousZoneFile returns the record of the last zone file of
+// the TLD of rec (before its date); if the file is still in the
+// same store.
+func (c *CzdsAPI) previousZoneFile(rec DownloadRecord) (DownloadRecord, bool) {
+	prev, ok := c.icann.store.latest(rec.TLD, rec.Date)
+	if !ok || prev.SHA256 == "" || filepath.Dir(prev.LocalFilePath) != filepath.Dir(rec.LocalFilePath) {
+		return DownloadRecord{}, false
+	}
+
+	store, name := c.zoneStoreFor(prev.LocalFilePath)
+	if _, err := store.Stat(c.icann.context(), name); err != nil {
+		return DownloadRecord{}, false
+	}
+
+	return prev, true
+}
+
+// sameVersion returns true if the remote file (HEAD) is the one that
+// rec was downloaded from; a strong ETag is preferred over Last-Modified.
+func (rec DownloadRecord) sameVersion(fs ZoneFileStatus) bool {
+	if fs.FileLength > 0 && rec.ContentLength > 0 && int64(fs.FileLength) != rec.ContentLength {
+		return false
+	}
+	if fs.ETag != "" && !strings.HasPrefix(fs.ETag, "W/") {
+		return fs.ETag == rec.ETag
+	}
+	return fs.LastModified != "" && fs.LastModified == rec.LastModified
+}
+
+// linkUnchangedZoneFile links the previous zone file to the new date
+// (see ZoneLinker; a hardlink in a LocalZoneStore) so that there is a
+// zone file for each day.
+func (c *CzdsAPI) linkUnchangedZoneFile(prev DownloadRecord, rec *DownloadRecord) error {
+
+	ctx := c.icann.context()
+	store, name := c.zoneStoreFor(rec.LocalFilePath)
+
+	if ls, ok := store.(*LocalZoneStore); ok {
+		removePartFile(getPartFilePath(ls.path(name)))
+	}
+
+	if err := linkZoneObject(ctx, store, filepath.Base(prev.LocalFilePath), name); err != nil {
+		return err
+	}
+	if err := putSHA256(ctx, store, name, prev.SHA256); err != nil {
+		return err
+	}
+
+	rec.SHA256 = prev.SHA256
+	rec.BytesReceived = prev.BytesReceived
+	rec.UnchangedSince = prev.UnchangedSince
+	if rec.UnchangedSince == "" {
+		rec.UnchangedSince = prev.Date
+	}
+
+	c.icann.emit(Event{Type: EventDownloadUnchanged, TLD: rec.TLD, URL: rec.URL,
+		LocalFilePath: rec.LocalFilePath, Bytes: uint64(rec.BytesReceived), UnchangedSince: rec.UnchangedSince})
+
+	return nil
+}
+
+// linkFile creates newPath as a hardlink of oldPath; or a
+// (relative) symlink if hardlinks are not supported. A symlink
+// points at the file itself; not at another symlink.
+func linkFile(oldPath string, newPath string) error {
+	os.Remove(newPath)
+
+	if target, err := filepath.EvalSymlinks(oldPath); err == nil {
+		oldPath = target
+	}
+
+	err := os.Link(oldPath, newPath)
+	if err == nil {
+		return nil
+	}
+
+	target := oldPath
+	if rel, rerr := filepath.Rel(filepath.Dir(newPath), oldPath); rerr == nil {
+		target = rel
+	}
+	if serr := os.Symlink(target, newPath); serr != nil {
+		return fmt.Errorf("unable to link %s to %s: %v; %v", newPath, oldPath, err, serr)
+	}
+
+	return nil
+}
+
+// hashFile writes the first n bytes of a file to w.
+func hashFile(w io.Writer, filePath string, n int64) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(w, f, n)
+
+	return err
+}
+
+// getZoneFileDate returns the date in the name of a zone file
+// (YYYY-MM-DD-<tld>.zone.gz); today if the name has no date.
+func getZoneFileDate(localFilePath string) string {
+	if date, _, ok := parseZoneFileName(path.Base(localFilePath)); ok {
+		return date
+	}
+	return time.Now().Format("2006-01-02")
+}
+
+// getResumeOffset returns the number of bytes that can be kept
+// from a previous (partial) download. The partial file is removed
+// if it cannot be validated against the remote file; i.e. there is
+// no ETag/Last-Modified, or the remote zone file has changed.
+func (c *CzdsAPI) getResumeOffset(tempFilePath string, fs ZoneFileStatus) int64 {
+
+	fi, err := os.Stat(tempFilePath)
+	if err != nil {
+		return 0
+	}
+
+	pi, err := readPartInfo(tempFilePath)
+	if err != nil || fs.validator() == "" || !pi.matches(fs) ||
+		(fs.FileLength > 0 && uint64(fi.Size()) > fs.FileLength) {
+		removePartFile(tempFilePath)
+		return 0
+	}
+
+	return fi.Size()
+}
+
+// getPartFilePath returns the path of the partial
+// download of localFilePath.
+func getPartFilePath(localFilePath string) string {
+	return localFilePath + partFileExt
+}
+
+// readPartInfo reads the validator saved next
+// to a partial download.
+func readPartInfo(tempFilePath string) (partFileInfo, error) {
+	var pi partFileInfo
+
+	b, err := os.ReadFile(tempFilePath + partInfoFileExt)
+	if err != nil {
+		return pi, err
+	}
+	err = json.Unmarshal(b, &pi)
+
+	return pi, err
+}
+
+// writePartInfo saves the validator of a partial download.
+func writePartInfo(tempFilePath string, pi partFileInfo) error {
+	b, _ := json.Marshal(pi)
+	return os.WriteFile(tempFilePath+partInfoFileExt, b, 0644)
+}
+
+// removePartFile removes a partial download and its validator.
+func removePartFile(tempFilePath string) {
+	os.Remove(tempFilePath)
+	os.Remove(tempFilePath + partInfoFileExt)
+}
+
+// completeZoneFile verifies the partial file (unless it was verified
+// during the download), writes the .sha256 file, and renames it to name.
+func completeZoneFile(ls *LocalZoneStore, tempFilePath string, name string, fs ZoneFileStatus, digest string, verified bool) error {
+
+	if !verified {
+		if err := verifyZoneFile(tempFilePath, fs.FileLength, fs.TLDType); err != nil {
+			discardPartFile(tempFilePath, fs, err)
+			return err
+		}
+	}
+
+	if err := putSHA256(context.Background(), ls, name, digest); err != nil {
+		return err
+	}
+
+	return finishPartFile(tempFilePath, ls.path(name))
+}
+
+// discardPartFile removes a partial file that failed a check; a short
+// file is kept to be resumed, but one that is damaged (or too long) is not.
+func discardPartFile(tempFilePath string, fs ZoneFileStatus, err error) {
+
+	var ie *IntegrityError
+	if !errors.As(err, &ie) {
+		return
+	}
+	fi, serr := os.Stat(tempFilePath)
+	if serr == nil && fs.FileLength > 0 && uint64(fi.Size()) < fs.FileLength {
+		return
+	}
+
+	removePartFile(tempFilePath)
+}
+
+// finishPartFile renames the partial download to
+// its final name; and removes its validator.
+func finishPartFile(tempFilePath string, localFilePath string) error {
+	if err := os.Rename(tempFilePath, localFilePath); err != nil {
+		return err
+	}
+	os.Remove(tempFilePath + partInfoFileExt)
+
+	return nil
+}
+
+// getContentRangeStart returns the first byte position
+// of a Content-Range header (e.g. bytes 1000-4999/5000);
+// -1 if the header is missing or malformed.
+func getContentRangeStart(hd http.Header) int64 {
+	cr := strings.TrimPrefix(hd.Get("Content-Range"), "bytes ")
+	v := strings.Split(cr, "-")
+	if len(v) < 2 {
+		return -1
+	}
+	n, err := strconv.ParseInt(v[0], 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return n
+}
+
+// validator returns the value to send with If-Range;
+// a strong ETag is preferred over Last-Modified.
+func (fs ZoneFileStatus) validator() string {
+	if fs.ETag != "" && !strings.HasPrefix(fs.ETag, "W/") {
+		return fs.ETag
+	}
+	return fs.LastModified
+}
+
+// matches returns true if the partial download was
+// made from the same remote file as fs.
+func (pi partFileInfo) matches(fs ZoneFileStatus) bool {
+	if pi.FileLength != fs.FileLength {
+		return false
+	}
+	if fs.ETag != "" && !strings.HasPrefix(fs.ETag, "W/") {
+		return pi.ETag == fs.ETag
+	}
+	return fs.LastModified != "" && pi.LastModified == fs.LastModified
+}
@@ -0,0 +1,313 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AccessRequestStatus is the status of a zone access request;
+// always in lower case.
+type AccessRequestStatus string
+
+const (
+	AccessRequestSubmitted AccessRequestStatus = "submitted"
+	AccessRequestPending   AccessRequestStatus = "pending"
+	AccessRequestApproved  AccessRequestStatus = "approved"
+	AccessRequestDenied    AccessRequestStatus = "denied"
+	AccessRequestRevoked   AccessRequestStatus = "revoked"
+	AccessRequestExpired   AccessRequestStatus = "expired"
+	AccessRequestCanceled  AccessRequestStatus = "canceled"
+)
+
+// accessRequestPageSize is the page size used to list the access
+// requests; CZDS caps it at 100.
+const accessRequestPageSize = 100
+
+// ErrTermsNotAccepted is returned by SubmitAccessRequest if the
+// version of the terms and conditions is not set.
+var ErrTermsNotAccepted = errors.New("the terms and conditions have not been accepted (TCVersion is blank)")
+
+// CzdsTime is a time in the CZDS responses. CZDS uses a few
+// formats (e.g. 2019-02-19T19:53:13.000+0000); a null or
+// blank value is the zero time.
+type CzdsTime struct {
+	time.Time
+}
+
+var czdsTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000-0700",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05.000",
+	"2006-01-02",
+}
+
+func (t *CzdsTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if string(b) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	for _, layout := range czdsTimeLayouts {
+		if v, err := time.Parse(layout, s); err == nil {
+			t.Time = v
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid time %q", s)
+}
+
+func (t CzdsTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.Format(time.RFC3339Nano))
+}
+
+// AccessRequest is an item of ListAccessRequests.
+type AccessRequest struct {
+	RequestID   string              `json:"requestId"`
+	TLD         string              `json:"tld"`
+	ULabel      string              `json:"ulabel"`
+	Status      AccessRequestStatus `json:"status"`
+	Created     CzdsTime            `json:"created"`
+	LastUpdated CzdsTime            `json:"lastUpdated"`
+
+	// Expired is the expiry of the approval; zero if the
+	// request has not been approved.
+	Expired CzdsTime `json:"expired"`
+	SFTP    bool     `json:"sftp"`
+}
+
+// AccessRequestTLD is the TLD of an AccessRequestDetail.
+type AccessRequestTLD struct {
+	TLD           string `json:"tld"`
+	ULabel        string `json:"ulabel"`
+	CurrentStatus string `json:"currentStatus"`
+	SFTP          bool   `json:"sftp"`
+}
+
+// AccessRequestHistory is an entry in the history of a request.
+type AccessRequestHistory struct {
+	Timestamp CzdsTime `json:"timestamp"`
+	Action    string   `json:"action"`
+	Comment   string   `json:"comment"`
+}
+
+// AccessRequestDetail is the response of GetAccessRequest.
+type AccessRequestDetail struct {
+	RequestID          string                 `json:"requestId"`
+	TLD                AccessRequestTLD       `json:"tld"`
+	FtpIPs             []string               `json:"ftpips"`
+	Status             AccessRequestStatus    `json:"status"`
+	TCVersion          string                 `json:"tcVersion"`
+	Created            CzdsTime               `json:"created"`
+	LastUpdated        CzdsTime               `json:"lastUpdated"`
+	Reason             string                 `json:"reason"`
+	History            []AccessRequestHistory `json:"history"`
+	Expired            CzdsTime               `json:"expired"`
+	Cancellable        bool                   `json:"cancellable"`
+	Extensible         bool                   `json:"extensible"`
+	ExtensionInProcess bool                   `json:"extensionInProcess"`
+}
+
+// AccessRequestFilter narrows ListAccessRequests; the zero
+// value lists all requests.
+type AccessRequestFilter struct {
+	// Status is one of the AccessRequestStatus values; blank for all.
+	Status AccessRequestStatus `json:"status"`
+
+	// TLD is a (partial) TLD name to search for.
+	TLD string `json:"filter"`
+}
+
+// NewAccessRequest is the body of SubmitAccessRequest.
+type NewAccessRequest struct {
+	// AllTLDs requests access to all the TLDs that are
+	// available; TLDs is ignored if true.
+	AllTLDs bool     `json:"allTlds"`
+	TLDs    []string `json:"tldNames"`
+	Reason  string   `json:"reason"`
+
+	// TCVersion is the version of the terms and conditions
+	// (see GetTermsAndConditions); setting it accepts them.
+	TCVersion string   `json:"tcVersion"`
+	FtpIPs    []string `json:"additionalFtpIps,omitempty"`
+}
+
+// TermsAndConditions is the current version of the CZDS
+// terms and conditions.
+type TermsAndConditions struct {
+	Version    string   `json:"version"`
+	Content    string   `json:"content"`
+	ContentURL string   `json:"contentUrl"`
+	Created    CzdsTime `json:"created"`
+}
+
+// ListAccessRequests returns the zone access requests of the
+// account; all the pages are read.
+func (c *CzdsAPI) ListAccessRequests(filter AccessRequestFilter) ([]AccessRequest, error) {
+
+	type pagination struct {
+		Size int `json:"size"`
+		Page int `json:"page"`
+	}
+	type sortx struct {
+		Field     string `json:"field"`
+		Direction string `json:"direction"`
+	}
+	type listRequest struct {
+		AccessRequestFilter
+		Pagination pagination `json:"pagination"`
+		Sort       sortx      `json:"sort"`
+	}
+	var listRes struct {
+		Requests      []AccessRequest `json:"requests"`
+		TotalRequests int             `json:"totalRequests"`
+	}
+
+	var list []AccessRequest
+	req := listRequest{AccessRequestFilter: filter,
+		Pagination: pagination{Size: accessRequestPageSize},
+		Sort:       sortx{Field: "created", Direction: "desc"}}
+
+	for {
+		listRes.Requests = nil
+		if err := c.czdsCall("ListAccessRequests", POST, czdsAPIRequestsPath+"/all", req, &listRes); err != nil {
+			return nil, err
+		}
+
+		for _, r := range listRes.Requests {
+			r.Status = normalizeRequestStatus(r.Status)
+			list = append(list, r)
+		}
+
+		if len(listRes.Requests) < accessRequestPageSize || len(list) >= listRes.TotalRequests {
+			break
+		}
+		req.Pagination.Page++
+	}
+
+	return list, nil
+}
+
+// GetAccessRequest returns the details of a request; including
+// the expiry of the approval.
+func (c *CzdsAPI) GetAccessRequest(requestID string) (AccessRequestDetail, error) {
+
+	var d AccessRequestDetail
+
+	err := c.czdsCall("GetAccessRequest", GET, czdsAPIRequestsPath+"/"+url.PathEscape(requestID), nil, &d)
+	d.Status = normalizeRequestStatus(d.Status)
+
+	return d, err
+}
+
+// GetTermsAndConditions returns the current terms and conditions;
+// their Version is needed to submit new requests.
+func (c *CzdsAPI) GetTermsAndConditions() (TermsAndConditions, error) {
+
+	var tc TermsAndConditions
+
+	err := c.czdsCall("GetTermsAndConditions", GET, czdsAPITermsPath, nil, &tc)
+
+	return tc, err
+}
+
+// AcceptTermsAndConditions returns the current terms and conditions
+// after setting their version in r; which is how CZDS records the
+// acceptance when r is submitted.
+func (c *CzdsAPI) AcceptTermsAndConditions(r *NewAccessRequest) (TermsAndConditions, error) {
+
+	tc, err := c.GetTermsAndConditions()
+	if err != nil {
+		return tc, err
+	}
+
+	r.TCVersion = tc.Version
+
+	return tc, nil
+}
+
+// SubmitAccessRequest requests access to r.TLDs (or to all TLDs).
+// The terms and conditions must be accepted; see AcceptTermsAndConditions.
+func (c *CzdsAPI) SubmitAccessRequest(r NewAccessRequest) error {
+
+	if r.TCVersion == "" {
+		return ErrTermsNotAccepted
+	}
+	if !r.AllTLDs && len(r.TLDs) == 0 {
+		return errors.New("SubmitAccessRequest()=> no TLDs")
+	}
+	if r.TLDs == nil {
+		r.TLDs = []string{}
+	}
+
+	return c.czdsCall("SubmitAccessRequest", POST, czdsAPIRequestsPath+"/create", r, nil)
+}
+
+// CancelAccessRequest cancels a request of a TLD; only pending
+// and approved requests can be cancelled.
+func (c *CzdsAPI) CancelAccessRequest(requestID string, tld string) error {
+
+	body := map[string]string{"integrationId": requestID, "tldName": tld}
+
+	return c.czdsCall("CancelAccessRequest", POST,
+		czdsAPIRequestsPath+"/"+url.PathEscape(requestID)+"/cancellation", body, nil)
+}
+
+// ExtendAccessRequest requests the extension of an approval; CZDS
+// allows it only near the expiry (see AccessRequestDetail.Extensible).
+func (c *CzdsAPI) ExtendAccessRequest(requestID string) error {
+	return c.czdsCall("ExtendAccessRequest", POST,
+		czdsAPIRequestsPath+"/extension/"+url.PathEscape(requestID), nil, nil)
+}
+
+// czdsCall sends body (as json, if not nil) to a CZDS endpoint with
+// the bearer token, and decodes the response into out (if not nil).
+// Any status other than 2xx is returned as an *APIError (or
+// *TokenError if the token was rejected).
+func (c *CzdsAPI) czdsCall(op string, method string, path string, body any, out any) error {
+
+	var data []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		data = b
+	}
+
+	urlx := c.icann.getCzdsURL(path)
+	res := c.icann.czdsExec(method, urlx, data)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return czdsError(op, urlx, res.StatusCode, res.ResponseHeaders, res.ResponseBody)
+	}
+
+	if out == nil || len(res.ResponseBody) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(res.ResponseBody, out)
+}
+
+func normalizeRequestStatus(s AccessRequestStatus) AccessRequestStatus {
+	return AccessRequestStatus(strings.ToLower(string(s)))
+}
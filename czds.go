@@ -2,11 +2,13 @@
 package icannclient
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
+	"io"
+	"io/fs"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,36 +24,116 @@ type CzdsAPI struct {
 type ICzdsAPI interface {
 	DownloadZoneFile(localFilePath string, downloadLink string, wg *sync.WaitGroup) (int, error)
 	ICANN() *IcannAPI
-	Run()
+	Run() error
+	CheckApprovals() (ApprovalReport, error)
+	DownloadRecords(date string) []DownloadRecord
+	PruneZoneFiles(dryRun bool) ([]PrunedZoneFile, error)
+
+	// zone access requests
+	ListAccessRequests(filter AccessRequestFilter) ([]AccessRequest, error)
+	GetAccessRequest(requestID string) (AccessRequestDetail, error)
+	GetTermsAndConditions() (TermsAndConditions, error)
+	AcceptTermsAndConditions(r *NewAccessRequest) (TermsAndConditions, error)
+	SubmitAccessRequest(r NewAccessRequest) error
+	CancelAccessRequest(requestID string, tld string) error
+	ExtendAccessRequest(requestID string) error
 }
 
-// Run will download the authorized zone files once every >24 hours.
-func (c *CzdsAPI) Run() {
+// Run will download each authorized zone file when it is due; every
+// HoursToWaitBetweenDownloads (>= 24 hours) or per Config.Schedules.
+// It returns when the context passed to New is cancelled (ctx.Err()),
+// or on an error that cannot be recovered by waiting.
+func (c *CzdsAPI) Run() error {
+
+	ctx := c.icann.context()
 
 lblAgain:
 
-	c.waitUntilAutenticated()
+	if err := c.waitUntilAutenticated(); err != nil {
+		return err
+	}
+
+	dlinks, err := c.getDownloadLinks()
+	if err != nil {
+		return err
+	}
+
+	// see which approvals have dropped out (or are about
+	// to) before downloading; once a day.
+	if c.icann.WatchApprovals && time.Since(c.icann.lastApprovalCheck) >= 24*time.Hour {
+		if _, err := c.checkApprovals(dlinks); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.icann.logger().Error("approval check failed", "error", err)
+		}
+		c.icann.lastApprovalCheck = time.Now()
+	}
 
-	dlinks := c.getDownloadLinks()
 	if len(dlinks) == 0 {
-		log.Fatal("unable to get download-links")
+		// (e.g. the approvals have expired, or not yet granted)
+		c.icann.logger().Warn("no download-links; waiting for the next interval")
+		c.icann.emit(Event{Type: EventCycleFinished})
+		if err := c.keepIdlUntilNextInternval(dlinks); err != nil {
+			return err
+		}
+		goto lblAgain
+	}
+
+	// only the TLDs in ApprovedTLD (and not in ExcludedTLD)
+	allLinks := len(dlinks)
+	dlinks, missing := c.filterDownloadLinks(dlinks)
+	if allLinks != len(dlinks) {
+		c.icann.logger().Info("download-links filtered", "links", allLinks, "selected", len(dlinks))
+	}
+	if !c.icann.WatchApprovals {
+		// (the approval check reports them with more detail)
+		for _, tld := range missing {
+			c.icann.emit(Event{Type: EventApprovalMissing, TLD: tld})
+		}
 	}
 
 	// tldUnq is an array to keep track items already downloaded.
 	// This list avoid any originated duplicates (i.e. net,net,com)
 	var tldUnq []interface{}
 
+	// failedTLDs are the TLDs that failed in this session;
+	// a successful retry removes the TLD.
+	failedTLDs := make(map[string]bool)
+
+	// the zone files downloaded in this cycle; to be diffed (the
+	// cycle may end on the next day).
+	var cycleFiles []string
+
 	// go through the loop from the bottom so that the latest
 	// gets downloaded first.
 	for i := (len(dlinks) - 1); i >= 0; i-- {
 
+		link := dlinks[i]
+
+		// only the TLDs that are due (interval, time window,
+		// and ICANN's 24 hours); as of now, since the batch
+		// can take hours.
+		if !c.icann.scheduler.due(getTLDFromDownloadLink(link), time.Now()) {
+			continue
+		}
+
 		// still check for authentication between downloads
-		c.waitUntilAutenticated()
+		if err := c.waitUntilAutenticated(); err != nil {
+			return err
+		}
 
-		link := dlinks[i]
 		localFilePath := c.getDownloadLocalFilePath(link)
 
-		if c.todayZoneFileExistsOnDisk(localFilePath) {
+		// tried too many times today, or denied
+		rec, ok := c.icann.store.get(getTLDFromDownloadLink(link), getZoneFileDate(localFilePath))
+		if ok && (rec.Status == DownloadDenied || rec.Status == DownloadFailed && rec.Attempts >= maxDownloadAttempts) {
+			continue
+		}
+
+		// (an error is reported by DownloadZoneFile)
+		if complete, _ := c.zoneFileComplete(localFilePath, link); complete {
+			c.recordExistingZoneFile(localFilePath, link)
 			continue
 		}
 
@@ -70,174 +152,235 @@ lblAgain:
 		// wait for each download to finish
 		// (one file at a time per ip addr; as it's not a good idea
 		// to download files simultaneousely from the same ip addr!).
-		statusCode, err := c.DownloadZoneFile(localFilePath, link, nil)
+		started := time.Now()
+		_, err = c.DownloadZoneFile(localFilePath, link, nil)
+		c.recordSchedule(link, started, err)
 		if err != nil {
-			fmt.Println(" c.DownloadZoneFile()=>", oneTLD, err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
 			// remove from the downloaded-list (success list)
 			tldUnq = RemoveFromArray(tldUnq, oneTLD)
+			failedTLDs[getTLDFromDownloadLink(link)] = true
+
+			c.emitDownloadFailed(getTLDFromDownloadLink(link), getZoneFileDate(localFilePath), err)
 
-			c.downloadZoneFilePostErr(localFilePath, link, oneTLD, statusCode, err)
+			// a denied TLD says nothing about the connection
+			var zae *ZoneAccessError
+			if errors.As(err, &zae) {
+				continue
+			}
 
 			// // it's a good idea to halt the download a bit
-			time.Sleep(time.Minute)
+			if err := sleepContext(ctx, time.Minute); err != nil {
+				return err
+			}
+			continue
 		}
+
+		cycleFiles = append(cycleFiles, localFilePath)
 	}
 
 	// download loop is done. Now see if there are any failures
-	c.downloadFailedTLDs()
-
-	c.cleanup()
-
-	c.keepIdlUntilNextInternval()
-
-	goto lblAgain
-}
-
-// todayZoneFileExistsOnDisk determins if a zone file
-// for the current session is present on disk; so that
-// if this app is turned off/on, and a successfully downloaded
-// zone file exists; it will not be re-downloaded.
-func (c *CzdsAPI) todayZoneFileExistsOnDisk(fp string) bool {
-
-	fileName := filepath.Base(fp)
-
-	// com => ~ 5 GB
-	// net => ~ 500 MB
-	files, err := os.ReadDir(c.icann.AppDataDir)
+	retried, err := c.downloadFailedTLDs()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	for _, rec := range retried {
+		delete(failedTLDs, rec.TLD)
+		cycleFiles = append(cycleFiles, rec.LocalFilePath)
 	}
 
-	for i := 0; i < len(files); i++ {
-		fn := files[i].Name()
-		if fileName == fn {
-			fi, _ := files[i].Info()
-			if strings.Contains(fileName, "-com.zone.gz") {
-				gb := fi.Size() / 1024 / 1024 / 1024
-				if gb > 4 {
-					return true
-				}
-			}
-			if strings.Contains(fileName, "-net.zone.gz") {
-				mb := fi.Size() / 1024 / 1024
-				if mb > 490 {
-					return true
-				}
-			}
+	if c.icann.DiffAfterDownload {
+		if err := c.diffZoneFilesOf(cycleFiles); err != nil {
+			c.icann.logger().Error("diff failed", "error", err)
 		}
 	}
 
-	return false
-}
-func (c *CzdsAPI) cleanup() {
+	if err := c.cleanup(); err != nil {
+		return err
+	}
 
-	// remove lingering partially downloaded files
-	files, err := os.ReadDir(c.icann.AppDataDir)
-	if err != nil {
-		log.Fatal(err)
+	if _, err := c.PruneZoneFiles(c.icann.RetentionDryRun); err != nil {
+		c.icann.logger().Error("pruning failed", "error", err)
 	}
 
-	for i := 0; i < len(files); i++ {
-		fn := files[i].Name()
+	c.icann.emit(Event{Type: EventCycleFinished, Links: len(dlinks),
+		Downloaded: len(tldUnq) + len(retried), Failed: len(failedTLDs),
+		FailedQueue: len(c.icann.store.retryable(time.Now().Format("2006-01-02")))})
 
-		if strings.HasSuffix(fn, ".part") {
-			fp := fmt.Sprintf("%s/%s", c.icann.AppDataDir, fn)
-			os.Remove(fp)
-		}
+	if err := c.keepIdlUntilNextInternval(dlinks); err != nil {
+		return err
 	}
+
+	goto lblAgain
 }
-func (c *CzdsAPI) downloadFailedTLDs() {
-	if len(c.icann.failedDownloadQueue) == 0 {
-		return
+
+// zoneFileComplete determines if a zone file is present in the
+// ZoneStore and complete; so that if this app is turned off/on (or is run
+// again on the same day) it will not be re-downloaded. The download
+// record decides; a file without a record (i.e. the record was not
+// saved, or it was downloaded by an older version) is complete if
+// it has a .sha256 file, or if it matches the remote Content-Length
+// and passes the checks of verifyZoneFile. Such a file is then recorded.
+func (c *CzdsAPI) zoneFileComplete(fp string, link string) (bool, error) {
+
+	ctx := c.icann.context()
+	store, name := c.zoneStoreFor(fp)
+
+	fi, err := store.Stat(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
-	c.removeMaxedoutItemsFromFailedDownloadList()
+	tld, date := getTLDFromDownloadLink(link), getZoneFileDate(fp)
 
-	for i := 0; i < len(c.icann.failedDownloadQueue); i++ {
-		statusCode, err := c.DownloadZoneFile(c.icann.failedDownloadQueue[i].LocalFilePath,
-			c.icann.failedDownloadQueue[i].DownloadURL, nil)
+	rec, found := c.icann.store.get(tld, date)
+	if found && rec.Status == DownloadCompleted {
+		return fi.Size == rec.BytesReceived, nil
+	}
 
+	// the .sha256 file is written after the checks
+	digest, err := getSHA256(ctx, store, name)
+	if err != nil {
+		zs, err := c.getZoneFileStatus(link)
 		if err != nil {
-			fmt.Println(" c.DownloadZoneFile()=>", c.icann.failedDownloadQueue[i].TLD, err)
-			c.downloadZoneFilePostErr(c.icann.failedDownloadQueue[i].LocalFilePath,
-				c.icann.failedDownloadQueue[i].DownloadURL, c.icann.failedDownloadQueue[i].TLD, statusCode, err)
+			return false, err
+		}
+		if zs.FileLength == 0 || uint64(fi.Size) != zs.FileLength {
+			return false, nil
+		}
 
-			time.Sleep(time.Minute)
+		r, err := store.Get(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		h := sha256.New()
+		tr := io.TeeReader(r, h)
+		err = verifyZoneStream(tr, name, zs.TLDType)
+		if err == nil {
+			_, err = io.Copy(io.Discard, tr)
+		}
+		r.Close()
+		if err != nil {
+			c.icann.logger().Warn("incomplete zone file", "tld", tld, "path", fp, "error", err)
+			return false, nil
 		}
 
-		c.removeMaxedoutItemsFromFailedDownloadList()
-		if len(c.icann.failedDownloadQueue) == 0 {
-			break
+		digest = hex.EncodeToString(h.Sum(nil))
+		if err := putSHA256(ctx, store, name, digest); err != nil {
+			return false, err
 		}
+		rec.ETag, rec.LastModified = zs.ETag, zs.LastModified
 	}
-}
-func (c *CzdsAPI) removeItemFromItemArry(s []failedDownloadItem, i int) []failedDownloadItem {
-	s[len(s)-1], s[i] = s[i], s[len(s)-1]
-	return s[:len(s)-1]
+
+	rec.TLD, rec.Date, rec.URL, rec.LocalFilePath = tld, date, link, fp
+	rec.Status = DownloadCompleted
+	rec.ContentLength, rec.BytesReceived = fi.Size, fi.Size
+	rec.SHA256 = digest
+	rec.LastError = ""
+	if rec.Attempts == 0 {
+		rec.Attempts = 1
+	}
+	if rec.Started.IsZero() {
+		rec.Started = fi.ModTime
+	}
+	rec.Finished = fi.ModTime
+
+	if err := c.icann.store.put(rec); err != nil {
+		c.icann.logger().Error("unable to save the download state", "tld", tld, "error", err)
+	}
+
+	return true, nil
 }
 
-func (c *CzdsAPI) removeMaxedoutItemsFromFailedDownloadList() {
-lblAgain:
-	for i := 0; i < len(c.icann.failedDownloadQueue); i++ {
+// cleanup removes lingering partially downloaded files (and temp
+// files) from the ZoneStore. Partial files of today's session are
+// kept; so that they can be resumed.
+func (c *CzdsAPI) cleanup() error {
 
-		// if error is: "...connection reset by peer", it won't do any good to re-try
-		// successively; hopfuly download for the next session will be successfull.
-		if c.icann.failedDownloadQueue[i].AttempCount > 2 ||
-			strings.Contains(c.icann.failedDownloadQueue[i].ErrTxt, "connection reset by peer") {
+	ctx := c.icann.context()
+	store := c.icann.ZoneStore
 
-			c.icann.failedDownloadQueue = c.removeItemFromItemArry(c.icann.failedDownloadQueue, i)
+	files, err := store.List(ctx, "")
+	if err != nil {
+		return err
+	}
 
-			if len(c.icann.failedDownloadQueue) == 0 {
-				return
-			}
+	todayPrefix := c.getFileNameFromDownloadLink("")
+
+	for i := 0; i < len(files); i++ {
+		fn := files[i].Name
 
-			goto lblAgain
+		if strings.HasPrefix(fn, todayPrefix) {
+			continue
 		}
 
+		if strings.HasSuffix(fn, partFileExt) || strings.HasSuffix(fn, partFileExt+partInfoFileExt) ||
+			strings.HasSuffix(fn, ".tmp") {
+			if err := store.Delete(ctx, fn); err != nil {
+				return err
+			}
+		}
 	}
+
+	return nil
 }
-func (c *CzdsAPI) downloadZoneFilePostErr(localFilePath string, link string, oneTLD string, statusCode int, err error) {
-	if err == nil {
-		return
-	}
 
-	for i := 0; i < len(c.icann.failedDownloadQueue); i++ {
-		if c.icann.failedDownloadQueue[i].TLD == oneTLD {
-			c.icann.failedDownloadQueue[i].AttempCount = c.icann.failedDownloadQueue[i].AttempCount + 1
-			return
-		}
-	}
+// downloadFailedTLDs tries the failed downloads of today once more
+// (up to maxDownloadAttempts); when the scheduler has them due (i.e.
+// after the backoff of the failure, and in their time window). It
+// returns the records of those that were downloaded successfully.
+func (c *CzdsAPI) downloadFailedTLDs() ([]DownloadRecord, error) {
 
-	var item = failedDownloadItem{
-		TLD:             oneTLD,
-		DateTimeAborted: time.Now(),
-		LocalFilePath:   localFilePath,
-		DownloadURL:     link,
-		AttempCount:     c.getFailedAttempCount(oneTLD) + 1,
-		StatusCode:      statusCode,
-		ErrTxt:          err.Error()}
+	var downloaded []DownloadRecord
 
-	c.icann.failedDownloadQueue = append(c.icann.failedDownloadQueue, item)
-}
+	ctx := c.icann.context()
+	items := c.icann.store.retryable(time.Now().Format("2006-01-02"))
 
-// getFailedAttempCount returns the attemp-count of an item
-// in the failed-attmpe queue.
-func (c *CzdsAPI) getFailedAttempCount(tld string) uint8 {
+	for i := 0; i < len(items); i++ {
+		if !c.icann.tldSelected(items[i].TLD) || !c.icann.scheduler.due(items[i].TLD, time.Now()) {
+			continue
+		}
 
-	var attpCnt uint8
-	lenx := len(c.icann.failedDownloadQueue)
+		started := time.Now()
+		_, err := c.DownloadZoneFile(items[i].LocalFilePath, items[i].URL, nil)
+		c.recordSchedule(items[i].URL, started, err)
 
-	if lenx == 0 {
-		return attpCnt
-	}
+		if err != nil {
+			if ctx.Err() != nil {
+				return downloaded, ctx.Err()
+			}
 
-	for i := 0; i < lenx; i++ {
-		if c.icann.failedDownloadQueue[i].TLD == tld {
-			return c.icann.failedDownloadQueue[i].AttempCount
+			c.emitDownloadFailed(items[i].TLD, items[i].Date, err)
+			continue
 		}
+
+		downloaded = append(downloaded, items[i])
 	}
 
-	return attpCnt
+	return downloaded, nil
+}
+
+// emitDownloadFailed sends EventDownloadFailed from the download
+// record; Queued is false once it has reached maxDownloadAttempts.
+func (c *CzdsAPI) emitDownloadFailed(tld string, date string, err error) {
+	rec, _ := c.icann.store.get(tld, date)
+
+	c.icann.emit(Event{Type: EventDownloadFailed, TLD: tld,
+		URL: rec.URL, LocalFilePath: rec.LocalFilePath, StatusCode: rec.StatusCode,
+		Attempt: rec.Attempts, Queued: rec.Status == DownloadFailed && rec.Attempts < maxDownloadAttempts,
+		FailedQueue: len(c.icann.store.retryable(date)), Err: err})
+}
+
+// DownloadRecords returns the download records of a day
+// (YYYY-MM-DD); all days if date is blank.
+func (c *CzdsAPI) DownloadRecords(date string) []DownloadRecord {
+	return c.icann.store.list(date)
 }
 
 // ICANN exposes the IcannAPI to outside callers (public).
@@ -246,17 +389,23 @@ func (c *CzdsAPI) ICANN() *IcannAPI {
 }
 
 // waitUntilAutenticated halts execution until the
-// icann session is autenticated.
-func (c *CzdsAPI) waitUntilAutenticated() {
+// icann session is autenticated. An expired token is
+// renewed here; attempts that were turned away (too many
+// requests, or status-code zero) are tried again after
+// the auth-attempt timeout.
+func (c *CzdsAPI) waitUntilAutenticated() error {
 	for {
-		authOK := c.icann.Authenticated
-
-		if !authOK {
-			time.Sleep(time.Second)
+		err := c.icann.ensureAccessToken()
+		if err == nil {
+			return nil
+		}
 
-		} else {
-			break
+		var authErr *AuthError
+		if errors.As(err, &authErr) && authErr.Temporary() {
+			continue
 		}
+
+		return err
 	}
 }
 
@@ -270,19 +419,16 @@ func (c *CzdsAPI) waitUntilAutenticated() {
 //	Content-Language:[en] Content-Length:[4979876869]
 //
 // To see all returned headers, see Result.ResponseHeaders.
-func (c *CzdsAPI) getZoneFileStatus(urlx string) ZoneFileStatus {
+func (c *CzdsAPI) getZoneFileStatus(urlx string) (ZoneFileStatus, error) {
 
 	var r ZoneFileStatus
 
-	fmt.Printf("\rgetting file-status...")
-
-	hd := c.icann.GetCommonHeaders()
-	hd.Add("Authorization", fmt.Sprintf("Bearer %s", c.icann.AccessToken.Token))
-
-	res := c.icann.HTTPExec(HEAD, urlx, hd, nil)
+	res := c.icann.czdsExec(HEAD, urlx, nil)
+	if res.Error != nil {
+		return r, res.Error
+	}
 	if res.StatusCode != 200 {
-		msg := fmt.Sprintf("error %d - %v", res.StatusCode, string(res.ResponseBody))
-		log.Fatal("getZoneFileStatus()=>", urlx, msg)
+		return r, czdsError("getZoneFileStatus", urlx, res.StatusCode, res.ResponseHeaders, res.ResponseBody)
 	}
 
 	sizeStr := fmt.Sprintf("%v", res.ResponseHeaders["Content-Length"])
@@ -294,35 +440,46 @@ func (c *CzdsAPI) getZoneFileStatus(urlx string) ZoneFileStatus {
 
 	x := fmt.Sprintf("%v", res.ResponseHeaders["Content-Disposition"])
 
-	fName = strings.Split(x, "=")[1]
+	v := strings.Split(x, "=")
+	if len(v) < 2 {
+		return r, fmt.Errorf("getZoneFileStatus()=> %s missing Content-Disposition filename", urlx)
+	}
+	fName = v[1]
 	fName = strings.ReplaceAll(fName, "[", "")
 	fName = strings.ReplaceAll(fName, "]", "")
 
 	r.TLDType = strings.Split(fName, ".")[0]
+	r.ETag = res.ResponseHeaders.Get("ETag")
+	r.LastModified = res.ResponseHeaders.Get("Last-Modified")
 
 	r.HTTPResult = res
 	r.OriginalFileName = fName
 
-	return r
+	return r, nil
 }
 
-// getDownloadLinks makes an http call to the czdsAPIDownloadLinksURL
+// getDownloadLinks makes an http call to the CZDS download-links endpoint
 // and receives the downloads for authrorized zone files.
-func (c *CzdsAPI) getDownloadLinks() []string {
+func (c *CzdsAPI) getDownloadLinks() ([]string, error) {
 
 	var dlinks []string
-	hd := c.icann.GetCommonHeaders()
-	hd.Add("Authorization", fmt.Sprintf("Bearer %s", c.icann.AccessToken.Token))
 
-	res := c.icann.HTTPExec(GET, czdsAPIDownloadLinksURL, hd, nil)
+	linksURL := c.icann.getDownloadLinksURL()
+	res := c.icann.czdsExec(GET, linksURL, nil)
+	if res.Error != nil {
+		return nil, res.Error
+	}
 	if res.StatusCode != 200 {
-		msg := fmt.Sprintf("error %d - %v", res.StatusCode, string(res.ResponseBody))
-		log.Fatal("getDownloadLinks()=>", msg)
+		return nil, czdsError("getDownloadLinks", linksURL, res.StatusCode, res.ResponseHeaders, res.ResponseBody)
+	}
+
+	if err := json.Unmarshal(res.ResponseBody, &dlinks); err != nil {
+		return nil, err
 	}
 
-	json.Unmarshal(res.ResponseBody, &dlinks)
+	c.icann.emit(Event{Type: EventLinksFetched, URL: linksURL, Links: len(dlinks)})
 
-	return dlinks
+	return dlinks, nil
 }
 
 // getFileNameFromDownloadLink concats the appdata path to
@@ -347,27 +504,54 @@ func (c *CzdsAPI) getDownloadLocalFilePath(link string) string {
 	return localFilePath
 }
 
-// keepIdlUntilNextInternval waits for >24 hours to download a file again.
-// According to ICANN terms callers must wait for at least
-// 24 hours between downloads...
-func (c *CzdsAPI) keepIdlUntilNextInternval() {
-	nextTime := time.Now().Add(time.Duration(c.icann.HoursToWaitBetweenDownloads) * time.Hour)
-	tCounter := c.icann.HoursToWaitBetweenDownloads * 60 * 60 // seconds
+// keepIdlUntilNextInternval waits until the next TLD is due (see
+// Config.Schedules). According to ICANN terms callers must wait for
+// at least 24 hours between downloads of the same zone file.
+func (c *CzdsAPI) keepIdlUntilNextInternval(dlinks []string) error {
 
-	fmt.Println("")
+	tlds := make([]string, 0, len(dlinks))
+	for _, link := range dlinks {
+		tlds = append(tlds, getTLDFromDownloadLink(link))
+	}
 
-	for {
-		if tCounter < 1 {
-			break
-		}
+	next := c.icann.scheduler.nextRun(tlds, time.Now())
+
+	c.icann.emit(Event{Type: EventIdle, NextRun: next})
 
-		t := time.Until(nextTime)
-		d := formatDuration(t)
-		fmt.Printf("\rdownload will resume in %s", d)
+	return sleepContext(c.icann.context(), time.Until(next))
+}
+
+// recordSchedule records the result of a download in the scheduler.
+func (c *CzdsAPI) recordSchedule(link string, started time.Time, err error) {
+
+	tld := getTLDFromDownloadLink(link)
+
+	if err == nil {
+		err = c.icann.scheduler.recordSuccess(tld, started)
+	} else if c.icann.context().Err() == nil {
+		err = c.icann.scheduler.recordFailure(tld, time.Now())
+	} else {
+		// cancelled; not a failure of the download
+		return
+	}
+
+	if err != nil {
+		c.icann.logger().Error("unable to save the schedule", "tld", tld, "error", err)
+	}
+}
+
+// recordExistingZoneFile records a zone file that was found in the
+// ZoneStore (downloaded before the schedule was kept) in the scheduler.
+func (c *CzdsAPI) recordExistingZoneFile(localFilePath string, link string) {
 
-		time.Sleep(time.Second)
+	store, name := c.zoneStoreFor(localFilePath)
+
+	fi, err := store.Stat(c.icann.context(), name)
+	if err != nil {
+		return
+	}
 
-		nextTime = nextTime.Add(-1 * time.Second)
-		tCounter--
+	if err := c.icann.scheduler.recordExisting(getTLDFromDownloadLink(link), fi.ModTime); err != nil {
+		c.icann.logger().Error("unable to save the schedule", "error", err)
 	}
 }
@@ -0,0 +1,503 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kambahr/go-icann-api-client/czdstest"
+)
+
+// testZone returns the text of a zone file of tld with n delegations.
+func testZone(tld string, n int) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s.\t900\tIN\tSOA\ta.nic.%s. hostmaster.nic.%s. 1 1800 900 604800 86400\n", tld, tld, tld)
+	fmt.Fprintf(&sb, "%s.\t172800\tIN\tNS\ta.nic.%s.\n", tld, tld)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "domain-%d-%x.%s.\t172800\tIN\tNS\tns%d.host-%x.net.\n", i, i*7919, tld, i%4, i*104729)
+	}
+
+	return sb.String()
+}
+
+// eventRecorder keeps the events sent to the observers.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) OnEvent(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, e)
+}
+
+// types returns the types of the events of tld; in order.
+func (r *eventRecorder) types(tld string) []EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var types []EventType
+	for _, e := range r.events {
+		if e.TLD == tld && e.Type != EventDownloadProgress {
+			types = append(types, e.Type)
+		}
+	}
+	return types
+}
+
+// first returns the first event of type t.
+func (r *eventRecorder) first(t EventType) Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range r.events {
+		if e.Type == t {
+			return e
+		}
+	}
+	return Event{}
+}
+
+// newTestClient returns the CzdsAPI of a client made by New
+// with cnf; the events are kept in the returned recorder.
+func newTestClient(t *testing.T, cnf Config) (*CzdsAPI, *eventRecorder) {
+
+	rec := &eventRecorder{}
+	cnf.Observers = append(cnf.Observers, rec)
+
+	icn, err := New(context.Background(), cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return icn.CzdsAPI.(*CzdsAPI), rec
+}
+
+// requests returns the requests (method and status) made for
+// the zone file of tld.
+func requests(s *czdstest.Server, tld string) []string {
+	var list []string
+	for _, r := range s.Requests() {
+		if strings.HasSuffix(r.Path, "/"+tld+".zone") {
+			list = append(list, fmt.Sprintf("%s %d", r.Method, r.Status))
+		}
+	}
+	return list
+}
+
+func checkZoneFile(t *testing.T, filePath string, want []byte) {
+	t.Helper()
+
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("%s: %d bytes; want the %d bytes served", filePath, len(b), len(want))
+	}
+}
+
+func TestNewAuthenticates(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	c, _ := newTestClient(t, testConfig(t, s))
+
+	if !c.icann.Authenticated() {
+		t.Error("not authenticated after New")
+	}
+	if n := s.AuthAttempts(); n != 1 {
+		t.Errorf("auth attempts = %d; want 1", n)
+	}
+
+	cnf := testConfig(t, s)
+	cnf.IcannAccountPassword = "wrong"
+	_, err := New(context.Background(), cnf)
+
+	var ae *AuthError
+	if !errors.As(err, &ae) || ae.StatusCode != http.StatusUnauthorized || ae.Temporary() {
+		t.Errorf("New with a wrong password = %v; want a 401 *AuthError", err)
+	}
+}
+
+func TestDownloadZoneFile(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	data := czdstest.GzipZone(testZone("com", 100))
+	s.AddZone("com", data)
+
+	c, events := newTestClient(t, testConfig(t, s))
+	fp := filepath.Join(c.icann.AppDataDir, "2026-10-17-com.zone.gz")
+
+	if _, err := c.DownloadZoneFile(fp, s.DownloadURL("com"), nil); err != nil {
+		t.Fatal(err)
+	}
+	checkZoneFile(t, fp, data)
+
+	rec, _ := c.icann.store.get("com", "2026-10-17")
+	if rec.Status != DownloadCompleted || rec.BytesReceived != int64(len(data)) || rec.SHA256 == "" {
+		t.Errorf("record = %+v", rec)
+	}
+
+	want := []EventType{EventDownloadStarted, EventDownloadCompleted}
+	if got := events.types("com"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events = %v; want %v", got, want)
+	}
+	if e := events.first(EventDownloadStarted); e.TotalBytes != uint64(len(data)) {
+		t.Errorf("started: total bytes = %d; want %d", e.TotalBytes, len(data))
+	}
+
+	// the file is complete; it is not downloaded again
+	if _, err := c.DownloadZoneFile(fp, s.DownloadURL("com"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests(s, "com"); fmt.Sprint(got) != "[HEAD 200 GET 200]" {
+		t.Errorf("requests = %v", got)
+	}
+}
+
+// A download that is cut off is resumed (Range; 206) from the
+// bytes that were received.
+func TestDownloadZoneFileResume(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	data := czdstest.GzipZone(testZone("com", 20000))
+	s.AddZone("com", data)
+	s.ResetConnectionAfter("com", int64(len(data)/2), 1)
+
+	c, _ := newTestClient(t, testConfig(t, s))
+	fp := filepath.Join(c.icann.AppDataDir, "2026-10-17-com.zone.gz")
+
+	if _, err := c.DownloadZoneFile(fp, s.DownloadURL("com"), nil); err == nil {
+		t.Fatal("the first download did not fail")
+	}
+	rec, _ := c.icann.store.get("com", "2026-10-17")
+	if rec.Status != DownloadFailed || rec.BytesReceived <= 0 {
+		t.Fatalf("record after the reset = %+v; want failed with a partial file", rec)
+	}
+	offset := rec.BytesReceived
+
+	if _, err := c.DownloadZoneFile(fp, s.DownloadURL("com"), nil); err != nil {
+		t.Fatal(err)
+	}
+	checkZoneFile(t, fp, data)
+
+	var ranges []string
+	for _, r := range s.Requests() {
+		if r.Method == http.MethodGet && r.Range != "" {
+			ranges = append(ranges, fmt.Sprintf("%s %d", r.Range, r.Status))
+		}
+	}
+	if want := fmt.Sprintf("[bytes=%d- 206]", offset); fmt.Sprint(ranges) != want {
+		t.Errorf("range requests = %v; want %s", ranges, want)
+	}
+	if _, err := os.Stat(getPartFilePath(fp)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the partial file is left behind: %v", err)
+	}
+}
+
+// A zone file that fails a check (during the download) is not
+// kept; nor is the partial file.
+func TestDownloadZoneFileDamaged(t *testing.T) {
+
+	noSOA := czdstest.GzipZone("com.\t172800\tIN\tNS\ta.nic.com.\n")
+	badCRC := czdstest.GzipZone(testZone("com", 20000))
+	badCRC[len(badCRC)-6] ^= 0xff
+
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		check string
+	}{
+		{"no SOA", noSOA, "soa"},
+		{"bad CRC", badCRC, "gzip"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			s := czdstest.NewServer()
+			defer s.Close()
+			s.AddZone("com", tc.data)
+
+			c, _ := newTestClient(t, testConfig(t, s))
+			fp := filepath.Join(c.icann.AppDataDir, "2026-10-17-com.zone.gz")
+
+			_, err := c.DownloadZoneFile(fp, s.DownloadURL("com"), nil)
+			var ie *IntegrityError
+			if !errors.As(err, &ie) || ie.Check != tc.check {
+				t.Fatalf("err = %v; want a %q *IntegrityError", err, tc.check)
+			}
+			for _, p := range []string{fp, getPartFilePath(fp)} {
+				if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s is left behind: %v", filepath.Base(p), err)
+				}
+			}
+		})
+	}
+}
+
+// A zone file that has not changed (ETag of the HEAD request) is
+// linked to the new date; it is not downloaded again.
+func TestDownloadZoneFileUnchanged(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	data := czdstest.GzipZone(testZone("com", 100))
+	s.AddZone("com", data)
+
+	c, events := newTestClient(t, testConfig(t, s))
+	fp1 := filepath.Join(c.icann.AppDataDir, "2026-10-16-com.zone.gz")
+	fp2 := filepath.Join(c.icann.AppDataDir, "2026-10-17-com.zone.gz")
+
+	if _, err := c.DownloadZoneFile(fp1, s.DownloadURL("com"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DownloadZoneFile(fp2, s.DownloadURL("com"), nil); err != nil {
+		t.Fatal(err)
+	}
+	checkZoneFile(t, fp2, data)
+
+	if got := requests(s, "com"); fmt.Sprint(got) != "[HEAD 200 GET 200 HEAD 200]" {
+		t.Errorf("requests = %v", got)
+	}
+	rec, _ := c.icann.store.get("com", "2026-10-17")
+	if rec.Status != DownloadCompleted || rec.UnchangedSince != "2026-10-16" {
+		t.Errorf("record = %+v", rec)
+	}
+
+	want := []EventType{EventDownloadStarted, EventDownloadCompleted, EventDownloadUnchanged}
+	if got := events.types("com"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events = %v; want %v", got, want)
+	}
+}
+
+// When the HEAD request cannot tell (no ETag in the previous record),
+// the GET is conditional; a 304 links the previous zone file.
+func TestDownloadZoneFileNotModified(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	data := czdstest.GzipZone(testZone("com", 100))
+	s.AddZone("com", data)
+
+	c, _ := newTestClient(t, testConfig(t, s))
+	fp1 := filepath.Join(c.icann.AppDataDir, "2026-10-16-com.zone.gz")
+	fp2 := filepath.Join(c.icann.AppDataDir, "2026-10-17-com.zone.gz")
+
+	if _, err := c.DownloadZoneFile(fp1, s.DownloadURL("com"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. recorded when the server did not send an ETag
+	prev, _ := c.icann.store.get("com", "2026-10-16")
+	prev.ETag = ""
+	if err := c.icann.store.put(prev); err != nil {
+		t.Fatal(err)
+	}
+
+	statusCode, err := c.DownloadZoneFile(fp2, s.DownloadURL("com"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotModified {
+		t.Errorf("status-code = %d; want 304", statusCode)
+	}
+	checkZoneFile(t, fp2, data)
+
+	if got := requests(s, "com"); fmt.Sprint(got) != "[HEAD 200 GET 200 HEAD 200 GET 304]" {
+		t.Errorf("requests = %v", got)
+	}
+	rec, _ := c.icann.store.get("com", "2026-10-17")
+	if rec.Status != DownloadCompleted || rec.UnchangedSince != "2026-10-16" {
+		t.Errorf("record = %+v", rec)
+	}
+}
+
+// A TLD whose approval was revoked (403) is a *ZoneAccessError;
+// it is recorded as denied.
+func TestDownloadZoneFileRevoked(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	s.AddZone("com", czdstest.GzipZone(testZone("com", 10)))
+	s.RevokeTLD("com")
+
+	c, events := newTestClient(t, testConfig(t, s))
+	fp := filepath.Join(c.icann.AppDataDir, "2026-10-17-com.zone.gz")
+
+	statusCode, err := c.DownloadZoneFile(fp, s.DownloadURL("com"), nil)
+
+	var ze *ZoneAccessError
+	if !errors.As(err, &ze) || ze.TLD != "com" {
+		t.Fatalf("err = %v; want a *ZoneAccessError for com", err)
+	}
+	if statusCode != http.StatusForbidden {
+		t.Errorf("status-code = %d; want 403", statusCode)
+	}
+
+	rec, _ := c.icann.store.get("com", "2026-10-17")
+	if rec.Status != DownloadDenied || rec.StatusCode != http.StatusForbidden {
+		t.Errorf("record = %+v; want denied (403)", rec)
+	}
+	if n := s.AuthAttempts(); n != 1 {
+		t.Errorf("auth attempts = %d; want 1", n)
+	}
+	// failed at HEAD; the download was not started
+	if got := events.types("com"); len(got) != 0 {
+		t.Errorf("events = %v; want none", got)
+	}
+}
+
+// A token that the server no longer accepts (401) is renewed;
+// and the request is sent again.
+func TestDownloadZoneFileTokenExpired(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	data := czdstest.GzipZone(testZone("com", 10))
+	s.AddZone("com", data)
+
+	c, _ := newTestClient(t, testConfig(t, s))
+	fp := filepath.Join(c.icann.AppDataDir, "2026-10-17-com.zone.gz")
+
+	s.ExpireTokens()
+
+	if _, err := c.DownloadZoneFile(fp, s.DownloadURL("com"), nil); err != nil {
+		t.Fatal(err)
+	}
+	checkZoneFile(t, fp, data)
+
+	if n := s.AuthAttempts(); n != 2 {
+		t.Errorf("auth attempts = %d; want 2", n)
+	}
+	if got := requests(s, "com"); fmt.Sprint(got) != "[HEAD 401 HEAD 200 GET 200]" {
+		t.Errorf("requests = %v", got)
+	}
+}
+
+// One cycle of Run: the approved TLDs are downloaded, a revoked
+// TLD is skipped; Run returns when the context is cancelled.
+func TestRun(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	com := czdstest.GzipZone(testZone("com", 10))
+	s.AddZone("com", com)
+	s.AddZone("net", czdstest.GzipZone(testZone("net", 10)))
+	s.AddZone("org", czdstest.GzipZone(testZone("org", 10)))
+	s.RevokeTLD("net")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cnf := testConfig(t, s)
+	cnf.ExcludedTLD = []string{"org"}
+
+	var cycle Event
+	cnf.Observers = []Observer{ObserverFunc(func(e Event) {
+		if e.Type == EventCycleFinished {
+			cycle = e
+			cancel()
+		}
+	})}
+
+	icn, err := New(ctx, cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := icn.CzdsAPI.(*CzdsAPI)
+
+	done := make(chan error, 1)
+	go func() { done <- c.Run() }()
+
+	select {
+	case err = <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v; want context.Canceled", err)
+	}
+
+	if cycle.Links != 2 || cycle.Downloaded != 1 || cycle.Failed != 1 {
+		t.Errorf("cycle: links %d, downloaded %d, failed %d; want 2, 1, 1", cycle.Links, cycle.Downloaded, cycle.Failed)
+	}
+
+	today := time.Now().Format("2006-01-02")
+	checkZoneFile(t, filepath.Join(c.icann.AppDataDir, today+"-com.zone.gz"), com)
+
+	if rec, _ := c.icann.store.get("net", today); rec.Status != DownloadDenied {
+		t.Errorf("net record = %+v; want denied", rec)
+	}
+	if got := requests(s, "org"); len(got) != 0 {
+		t.Errorf("excluded TLD requested: %v", got)
+	}
+}
+
+// Without download-links (e.g. no approvals yet) Run waits for
+// the next interval; it does not return.
+func TestRunWithoutLinks(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cnf := testConfig(t, s)
+
+	var types []EventType
+	var idle Event
+	cnf.Observers = []Observer{ObserverFunc(func(e Event) {
+		types = append(types, e.Type)
+		if e.Type == EventIdle {
+			idle = e
+			cancel()
+		}
+	})}
+
+	icn, err := New(ctx, cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- icn.CzdsAPI.Run() }()
+
+	select {
+	case err = <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v; want context.Canceled", err)
+	}
+
+	if fmt.Sprint(types) != fmt.Sprint([]EventType{EventAuthSucceeded, EventLinksFetched, EventCycleFinished, EventIdle}) {
+		t.Errorf("events = %v", types)
+	}
+	if d := time.Until(idle.NextRun); d < 23*time.Hour || d > 24*time.Hour {
+		t.Errorf("next run in %v; want the interval (24h)", d)
+	}
+}
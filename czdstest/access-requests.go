@@ -0,0 +1,332 @@
+// (c) Kamiar Bahri
+package czdstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// TermsVersion is the version of the terms and conditions;
+	// new requests must be submitted with it.
+	TermsVersion = "2.0"
+
+	// ApprovalLifetime is the lifetime of approved requests
+	// (and of the extensions).
+	ApprovalLifetime = 365 * 24 * time.Hour
+
+	// ExtensionWindow is how long before the expiry an
+	// approval can be extended.
+	ExtensionWindow = 30 * 24 * time.Hour
+)
+
+// AccessRequest is a zone access request kept by the server.
+type AccessRequest struct {
+	ID          string
+	TLD         string
+	Status      string // pending, approved, denied, revoked, expired, canceled
+	Reason      string
+	TCVersion   string
+	Created     time.Time
+	LastUpdated time.Time
+	Expires     time.Time
+
+	// Extensions is the number of times the approval was extended.
+	Extensions int
+}
+
+// accessRequestJSON is an AccessRequest as sent to the clients.
+type accessRequestJSON struct {
+	RequestID   string `json:"requestId"`
+	TLD         string `json:"tld"`
+	ULabel      string `json:"ulabel"`
+	Status      string `json:"status"`
+	Created     string `json:"created"`
+	LastUpdated string `json:"lastUpdated"`
+	Expired     any    `json:"expired"`
+	SFTP        bool   `json:"sftp"`
+}
+
+// AddAccessRequest adds a request of a TLD and returns its id; for
+// approved requests, expires is the expiry of the approval. The
+// download-links are not changed (see AddZone and RevokeTLD).
+func (s *Server) AddAccessRequest(tld string, status string, expires time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addAccessRequest(tld, status, "", TermsVersion, expires).ID
+}
+
+// AccessRequests returns a copy of the requests.
+func (s *Server) AccessRequests() []AccessRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []AccessRequest
+	for _, r := range s.accessRequests {
+		list = append(list, *r)
+	}
+
+	return list
+}
+
+// ApproveAccessRequest approves a request for ApprovalLifetime;
+// e.g. a request submitted by the client.
+func (s *Server) ApproveAccessRequest(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r := s.findAccessRequest(id); r != nil {
+		r.Status = "approved"
+		r.LastUpdated = s.Now()
+		r.Expires = r.LastUpdated.Add(ApprovalLifetime)
+	}
+}
+
+// addAccessRequest must be called with s.mu locked.
+func (s *Server) addAccessRequest(tld string, status string, reason string, tcVersion string, expires time.Time) *AccessRequest {
+	s.lastRequestID++
+	now := s.Now()
+
+	r := &AccessRequest{ID: fmt.Sprintf("%08x-0000-4000-8000-%012x", s.lastRequestID, s.lastRequestID),
+		TLD: tld, Status: status, Reason: reason, TCVersion: tcVersion,
+		Created: now, LastUpdated: now, Expires: expires}
+	s.accessRequests = append(s.accessRequests, r)
+
+	return r
+}
+
+// findAccessRequest must be called with s.mu locked.
+func (s *Server) findAccessRequest(id string) *AccessRequest {
+	for _, r := range s.accessRequests {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// extensible must be called with s.mu locked.
+func (s *Server) extensible(r *AccessRequest) bool {
+	left := r.Expires.Sub(s.Now())
+	return r.Status == "approved" && left > 0 && left <= ExtensionWindow
+}
+
+func (r *AccessRequest) toJSON() accessRequestJSON {
+	v := accessRequestJSON{RequestID: r.ID, TLD: r.TLD, ULabel: r.TLD, Status: r.Status,
+		Created: formatTime(r.Created), LastUpdated: formatTime(r.LastUpdated)}
+	if !r.Expires.IsZero() {
+		v.Expired = formatTime(r.Expires)
+	}
+	return v
+}
+
+// formatTime formats t the way CZDS does; e.g. 2019-02-19T19:53:13.000+0000.
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000-0700")
+}
+
+func (s *Server) handleAccessRequests(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authentication failed"})
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, requestsPath)
+
+	switch {
+	case rest == "all" && r.Method == http.MethodPost:
+		s.listAccessRequests(w, r)
+	case rest == "create" && r.Method == http.MethodPost:
+		s.createAccessRequests(w, r)
+	case strings.HasPrefix(rest, "extension/") && r.Method == http.MethodPost:
+		s.extendAccessRequest(w, strings.TrimPrefix(rest, "extension/"))
+	case strings.HasSuffix(rest, "/cancellation") && r.Method == http.MethodPost:
+		s.cancelAccessRequest(w, strings.TrimSuffix(rest, "/cancellation"))
+	case !strings.Contains(rest, "/") && r.Method == http.MethodGet:
+		s.getAccessRequest(w, rest)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+	}
+}
+
+func (s *Server) listAccessRequests(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status     string `json:"status"`
+		Filter     string `json:"filter"`
+		Pagination struct {
+			Size int `json:"size"`
+			Page int `json:"page"`
+		} `json:"pagination"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+		return
+	}
+	if req.Pagination.Size <= 0 || req.Pagination.Size > 100 {
+		req.Pagination.Size = 100
+	}
+
+	s.mu.Lock()
+	var matched []accessRequestJSON
+	for _, ar := range s.accessRequests {
+		if req.Status != "" && !strings.EqualFold(req.Status, ar.Status) {
+			continue
+		}
+		if req.Filter != "" && !strings.Contains(ar.TLD, strings.ToLower(req.Filter)) {
+			continue
+		}
+		matched = append(matched, ar.toJSON())
+	}
+	s.mu.Unlock()
+
+	// newest first
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Created > matched[j].Created })
+
+	start := req.Pagination.Page * req.Pagination.Size
+	end := start + req.Pagination.Size
+	if start > len(matched) {
+		start = len(matched)
+	}
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"requests": matched[start:end], "totalRequests": len(matched)})
+}
+
+func (s *Server) getAccessRequest(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ar := s.findAccessRequest(id)
+	if ar == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Request not found"})
+		return
+	}
+
+	v := ar.toJSON()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"requestId":          ar.ID,
+		"tld":                map[string]any{"tld": ar.TLD, "ulabel": ar.TLD, "currentStatus": ar.Status, "sftp": false},
+		"ftpips":             []string{},
+		"status":             ar.Status,
+		"tcVersion":          ar.TCVersion,
+		"created":            v.Created,
+		"lastUpdated":        v.LastUpdated,
+		"reason":             ar.Reason,
+		"history":            []any{},
+		"expired":            v.Expired,
+		"cancellable":        ar.Status == "pending" || ar.Status == "approved",
+		"extensible":         s.extensible(ar),
+		"extensionInProcess": false,
+	})
+}
+
+func (s *Server) createAccessRequests(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AllTLDs   bool     `json:"allTlds"`
+		TLDNames  []string `json:"tldNames"`
+		Reason    string   `json:"reason"`
+		TCVersion string   `json:"tcVersion"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+		return
+	}
+	if req.TCVersion != TermsVersion {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Terms and conditions must be accepted"})
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Reason is required"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tlds := req.TLDNames
+	if req.AllTLDs {
+		tlds = nil
+		for tld := range s.zones {
+			tlds = append(tlds, tld)
+		}
+		sort.Strings(tlds)
+	}
+	if len(tlds) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "No TLDs"})
+		return
+	}
+
+	for _, tld := range tlds {
+		s.addAccessRequest(strings.ToLower(tld), "pending", req.Reason, req.TCVersion, time.Time{})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) cancelAccessRequest(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ar := s.findAccessRequest(id)
+	if ar == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Request not found"})
+		return
+	}
+	if ar.Status != "pending" && ar.Status != "approved" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Request cannot be cancelled"})
+		return
+	}
+
+	ar.Status = "canceled"
+	ar.LastUpdated = s.Now()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// extendAccessRequest extends an approval right away (CZDS
+// may take a while to approve the extension).
+func (s *Server) extendAccessRequest(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ar := s.findAccessRequest(id)
+	if ar == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Request not found"})
+		return
+	}
+	if !s.extensible(ar) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Request cannot be extended"})
+		return
+	}
+
+	ar.Extensions++
+	ar.LastUpdated = s.Now()
+	ar.Expires = ar.Expires.Add(ApprovalLifetime)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleTerms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authentication failed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"version":    TermsVersion,
+		"content":    "<p>Zone File Access Agreement</p>",
+		"contentUrl": s.URL + "/czds/terms/condition.html",
+		"created":    "2019-01-01T00:00:00.000+0000",
+	})
+}
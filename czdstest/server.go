@@ -0,0 +1,509 @@
+// (c) Kamiar Bahri
+
+// Package czdstest runs an in-process fake of the ICANN account api
+// and the CZDS api, so that the client can be exercised without ICANN
+// credentials or network access. Point Config.AccountBaseURL and
+// Config.CzdsBaseURL at Server.URL.
+//
+// The following endpoints are simulated:
+//
+//	POST /api/authenticate          (8 attempts per 5 minutes; 429 after that)
+//	GET  /czds/downloads/links
+//	HEAD /czds/downloads/<tld>.zone (Content-Disposition, Content-Length,...)
+//	GET  /czds/downloads/<tld>.zone (Range and If-Range are supported)
+//	POST /czds/requests/all, /czds/requests/create
+//	GET  /czds/requests/<id>
+//	POST /czds/requests/<id>/cancellation, /czds/requests/extension/<id>
+//	GET  /czds/terms/condition
+//
+// Faults can be injected per TLD; see ResetConnectionAfter,
+// SetBodyRate, RevokeTLD, and ExpireTokens.
+package czdstest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AuthAttemptsPerWindow and AuthAttemptWindow model ICANN's
+	// limit of 8 authentication attempts in 5 minutes per IP address.
+	AuthAttemptsPerWindow = 8
+	AuthAttemptWindow     = 5 * time.Minute
+
+	// DefaultTokenLifetime is the lifetime of issued access tokens.
+	DefaultTokenLifetime = 24 * time.Hour
+
+	authenticatePath = "/api/authenticate"
+	linksPath        = "/czds/downloads/links"
+	downloadsPath    = "/czds/downloads/"
+	requestsPath     = "/czds/requests/"
+	termsPath        = "/czds/terms/condition"
+)
+
+// Server is a fake ICANN account + CZDS server.
+type Server struct {
+	*httptest.Server
+
+	// Now is the clock of the server; it can be replaced
+	// to simulate the passing of time.
+	Now func() time.Time
+
+	// TokenLifetime is the lifetime of the access tokens issued
+	// from now on; default is DefaultTokenLifetime.
+	TokenLifetime time.Duration
+
+	mu           sync.Mutex
+	users        map[string]string
+	zones        map[string]*zone
+	tokens       map[string]time.Time // token => expiry
+	authAttempts []time.Time
+	requests     []Request
+
+	accessRequests []*AccessRequest
+	lastRequestID  int
+}
+
+// Request is a record of a request received by the server.
+type Request struct {
+	Method string
+	Path   string
+	Range  string
+	Status int
+}
+
+// zone is a zone file served by the server, and its faults.
+type zone struct {
+	data    []byte
+	modTime time.Time
+	etag    string
+	revoked bool
+
+	// resetAfter is the number of body bytes to send before the
+	// connection is reset; -1 for no reset. resetCount is the number
+	// of downloads that are still to be reset.
+	resetAfter int64
+	resetCount int
+
+	// bytesPerSecond slows down the body; zero for no limit.
+	bytesPerSecond int
+}
+
+// NewServer starts a new fake server. Callers must call Close
+// when done.
+func NewServer() *Server {
+	s := &Server{
+		Now:           time.Now,
+		TokenLifetime: DefaultTokenLifetime,
+		users:         make(map[string]string),
+		zones:         make(map[string]*zone),
+		tokens:        make(map[string]time.Time),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(authenticatePath, s.handleAuthenticate)
+	mux.HandleFunc(linksPath, s.handleLinks)
+	mux.HandleFunc(downloadsPath, s.handleDownload)
+	mux.HandleFunc(requestsPath, s.handleAccessRequests)
+	mux.HandleFunc(termsPath, s.handleTerms)
+
+	s.Server = httptest.NewServer(s.logRequests(mux))
+
+	return s
+}
+
+// AddUser adds an ICANN account.
+func (s *Server) AddUser(username string, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[username] = password
+}
+
+// AddZone adds (or replaces) the zone file of a TLD; data is served
+// as-is (see GzipZone). Replacing a zone changes its ETag and
+// Last-Modified; as if a new zone file was published.
+func (s *Server) AddZone(tld string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := sha1.Sum(data)
+	z := &zone{data: data, modTime: s.Now().UTC().Truncate(time.Second),
+		etag: fmt.Sprintf(`"%s"`, hex.EncodeToString(h[:8])), resetAfter: -1}
+
+	if old, ok := s.zones[tld]; ok {
+		z.revoked = old.revoked
+		z.bytesPerSecond = old.bytesPerSecond
+	}
+	s.zones[tld] = z
+}
+
+// RevokeTLD makes the download of a TLD respond with 403; as if
+// the approval was revoked. The link is still listed.
+func (s *Server) RevokeTLD(tld string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if z, ok := s.zones[tld]; ok {
+		z.revoked = true
+	}
+}
+
+// ResetConnectionAfter makes the next count downloads of a TLD
+// reset the connection after n bytes of the body have been sent.
+func (s *Server) ResetConnectionAfter(tld string, n int64, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if z, ok := s.zones[tld]; ok {
+		z.resetAfter = n
+		z.resetCount = count
+	}
+}
+
+// SetBodyRate slows down the download of a TLD to about
+// bytesPerSecond; zero removes the limit.
+func (s *Server) SetBodyRate(tld string, bytesPerSecond int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if z, ok := s.zones[tld]; ok {
+		z.bytesPerSecond = bytesPerSecond
+	}
+}
+
+// ExpireTokens expires all access tokens issued so far; the
+// next calls with those tokens are responded with 401.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k := range s.tokens {
+		s.tokens[k] = time.Time{}
+	}
+}
+
+// AuthAttempts returns the number of authentication
+// attempts received so far.
+func (s *Server) AuthAttempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.authAttempts)
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// DownloadURL returns the download link of a TLD.
+func (s *Server) DownloadURL(tld string) string {
+	return fmt.Sprintf("%s%s%s.zone", s.URL, downloadsPath, tld)
+}
+
+// GzipZone compresses the text of a zone file; e.g. to pass to AddZone.
+func GzipZone(text string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(text))
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+func (s *Server) handleAuthenticate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	now := s.Now()
+
+	// sliding window of the attempts
+	var recent []time.Time
+	for _, t := range s.authAttempts {
+		if now.Sub(t) < AuthAttemptWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= AuthAttemptsPerWindow {
+		retryAfter := AuthAttemptWindow - now.Sub(recent[0])
+		s.mu.Unlock()
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"message": "Too many requests"})
+		return
+	}
+	s.authAttempts = append(s.authAttempts, now)
+	s.mu.Unlock()
+
+	var cred struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&cred); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+		return
+	}
+
+	s.mu.Lock()
+	pwd, ok := s.users[cred.Username]
+	s.mu.Unlock()
+
+	if !ok || pwd != cred.Password {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Invalid username or password"})
+		return
+	}
+
+	token := s.issueToken(now)
+
+	writeJSON(w, http.StatusOK, map[string]string{"accessToken": token, "message": "Authentication Successful"})
+}
+
+func (s *Server) handleLinks(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authentication failed"})
+		return
+	}
+
+	s.mu.Lock()
+	var tlds []string
+	for tld := range s.zones {
+		tlds = append(tlds, tld)
+	}
+	s.mu.Unlock()
+
+	sort.Strings(tlds)
+
+	links := make([]string, 0, len(tlds))
+	for _, tld := range tlds {
+		links = append(links, s.DownloadURL(tld))
+	}
+
+	writeJSON(w, http.StatusOK, links)
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authentication failed"})
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, downloadsPath)
+	tld := strings.TrimSuffix(name, ".zone")
+
+	s.mu.Lock()
+	z, ok := s.zones[tld]
+	var data []byte
+	var modTime time.Time
+	var etag string
+	var revoked bool
+	resetAfter := int64(-1)
+	var bytesPerSecond int
+	if ok {
+		data, modTime, etag, revoked, bytesPerSecond = z.data, z.modTime, z.etag, z.revoked, z.bytesPerSecond
+		if r.Method == http.MethodGet && z.resetCount > 0 {
+			resetAfter = z.resetAfter
+			z.resetCount--
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok || name == tld {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not found"})
+		return
+	}
+	if revoked {
+		writeJSON(w, http.StatusForbidden, map[string]string{"message": "Access to the zone file is denied"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s.txt.gz", tld))
+	w.Header().Set("ETag", etag)
+
+	var fw http.ResponseWriter = w
+	if resetAfter >= 0 || bytesPerSecond > 0 {
+		fw = &faultWriter{ResponseWriter: w, req: r, resetAfter: resetAfter, bytesPerSecond: bytesPerSecond}
+	}
+
+	http.ServeContent(fw, r, name, modTime, bytes.NewReader(data))
+}
+
+// authorized returns true if the request has a valid bearer token.
+func (s *Server) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exp, ok := s.tokens[token]
+
+	return ok && s.Now().Before(exp)
+}
+
+// issueToken creates a JWT shaped access token (the signature
+// is random) with iat and exp claims.
+func (s *Server) issueToken(now time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lifetime := s.TokenLifetime
+	if lifetime <= 0 {
+		lifetime = DefaultTokenLifetime
+	}
+	exp := now.Add(lifetime)
+
+	enc := base64.RawURLEncoding
+	hd, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]int64{"iat": now.Unix(), "exp": exp.Unix()})
+	sig := make([]byte, 32)
+	rand.Read(sig)
+
+	token := enc.EncodeToString(hd) + "." + enc.EncodeToString(claims) + "." + enc.EncodeToString(sig)
+	s.tokens[token] = exp
+
+	return token
+}
+
+// logRequests records every request and its status-code.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path,
+			Range: r.Header.Get("Range"), Status: sw.status})
+		s.mu.Unlock()
+	})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+// statusWriter keeps the status-code written to a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(statusCode int) {
+	sw.status = statusCode
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking is not supported")
+	}
+	return hj.Hijack()
+}
+
+// faultWriter slows down the body and/or resets the connection
+// after a number of bytes.
+type faultWriter struct {
+	http.ResponseWriter
+	req            *http.Request
+	written        int64
+	resetAfter     int64
+	bytesPerSecond int
+}
+
+func (fw *faultWriter) Write(p []byte) (int, error) {
+	var n int
+
+	for len(p) > 0 {
+		chunk := p
+		if fw.bytesPerSecond > 0 && len(chunk) > fw.bytesPerSecond/10+1 {
+			// about 10 writes per second
+			chunk = chunk[:fw.bytesPerSecond/10+1]
+		}
+		if fw.resetAfter >= 0 && fw.written+int64(len(chunk)) > fw.resetAfter {
+			chunk = chunk[:fw.resetAfter-fw.written]
+		}
+
+		m, err := fw.ResponseWriter.Write(chunk)
+		n += m
+		fw.written += int64(m)
+		p = p[m:]
+		if err != nil {
+			return n, err
+		}
+
+		if fw.resetAfter >= 0 && fw.written >= fw.resetAfter {
+			return n, fw.reset()
+		}
+
+		if fw.bytesPerSecond > 0 {
+			if f, ok := fw.ResponseWriter.(http.Flusher); ok {
+				f.Flush()
+			}
+			select {
+			case <-fw.req.Context().Done():
+				return n, fw.req.Context().Err()
+			case <-time.After(time.Duration(m) * time.Second / time.Duration(fw.bytesPerSecond)):
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// reset flushes what has been written so far and closes
+// the connection with a TCP RST.
+func (fw *faultWriter) reset() error {
+	if f, ok := fw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	hj, ok := fw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	conn.Close()
+
+	return fmt.Errorf("connection reset")
+}
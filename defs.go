@@ -2,49 +2,158 @@
 package icannclient
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
 	"os"
 	"time"
 )
 
-var mLastAuthenticationAttempt time.Time
-
 const (
 	GET  = "GET"
 	HEAD = "HEAD"
 	POST = "POST"
 
-	tokenFileName           string = "token.dat"
-	linux                   string = "linux"
-	czdsAPIBasedURL         string = "https://czds-api.icann.org"
-	czdsAPIDownloadLinksURL string = "https://czds-api.icann.org/czds/downloads/links"
-	authenticateBaseURL     string = "https://account-api.icann.org/api/authenticate"
+	tokenFileName   string = "token.dat"
+	partFileExt     string = ".part"
+	partInfoFileExt string = ".info"
+	sha256FileExt   string = ".sha256"
+
+	// DefaultCzdsBaseURL and DefaultAccountBaseURL are used
+	// when the base URLs are not set in Config.
+	DefaultCzdsBaseURL    string = "https://czds-api.icann.org"
+	DefaultAccountBaseURL string = "https://account-api.icann.org"
+
+	czdsAPIDownloadLinksPath string = "/czds/downloads/links"
+	czdsAPIRequestsPath      string = "/czds/requests"
+	czdsAPITermsPath         string = "/czds/terms/condition"
+	authenticatePath         string = "/api/authenticate"
 )
 
-// configData is used to initialize variables
-// from environment file.
-type configData struct {
-	AppDataDir                  string
-	UserAgent                   string
-	IcannAccountUserName        string
-	IcannAccountPassword        string
-	ApprovedTLD                 []string
-	ZoneFileDir                 string
+// Config holds the settings used by New to create a client.
+// ConfigFromEnv builds one from the environment variables
+// (and the icann.env file); if any.
+type Config struct {
+	// UserAgent is required for all ICANN API calls; its format is:
+	// <name of you product> / <version> <comment about your product>
+	UserAgent string
+
+	// ICANN person account username and password.
+	IcannAccountUserName string
+	IcannAccountPassword string
+
+	// ApprovedTLD are the TLDs to download e.g. com, net; glob patterns
+	// are supported (e.g. co*, x?z). Blank or * downloads all the TLDs
+	// that are approved. ExcludedTLD are not downloaded; even if
+	// they match ApprovedTLD.
+	ApprovedTLD []string
+	ExcludedTLD []string
+
+	// ZoneFileDir is the directory that zone files will be downloaded to.
+	// It is created if it does not exist. The state of the client (token,
+	// schedule, download records) is kept here; even with a ZoneStore.
+	ZoneFileDir string
+
+	// ZoneStore is where the zone files are kept (e.g. NewS3ZoneStore);
+	// default is a LocalZoneStore in ZoneFileDir.
+	ZoneStore ZoneStore
+
+	// DiskReserve is the space kept free on the volume of ZoneFileDir;
+	// a percent of the volume (e.g. 10%) or a size (e.g. 20GB). Default
+	// is 10%. It applies to the default (local) ZoneStore.
+	DiskReserve string
+
+	// DiskSpaceWait is how long a download waits for free disk-space
+	// (rechecked every minute) before it fails with *DiskSpaceError;
+	// default is an hour. A negative value fails at once.
+	DiskSpaceWait time.Duration
+
+	// TokenCache keeps the access token between runs; default is a
+	// FileTokenCache (token.dat in ZoneFileDir) encrypted with TokenKey.
+	// Instances that share one also share the token (see TokenCache).
+	TokenCache TokenCache
+
+	// TokenKey is the passphrase of the token file; ConfigFromEnv
+	// sets it from TOKEN_KEY, or else SALT_PHRASE. It is required
+	// unless TokenCache is set.
+	TokenKey string
+
+	// AuthLimiter paces the authentication attempts; default is one
+	// limiter shared by the clients of the process (or, with
+	// AuthLimitFile, by the processes that use the same file).
+	AuthLimiter   *AuthLimiter
+	AuthLimitFile string
+
+	// TokenRefreshMargin is how long before its expiry (the exp claim)
+	// the access token is renewed; default is an hour. It must be
+	// less than 12 hours.
+	TokenRefreshMargin time.Duration
+
+	// HoursToWaitBetweenDownloads is the time between two downloads of
+	// the same TLD; it cannot be less than 24 hours.
 	HoursToWaitBetweenDownloads int
-}
 
-// failedDownloadItem hold info on a filed download so that
-// the download can be tried again; after other files
-// are downloaded, since there is one download in-progress
-// at a time.
-type failedDownloadItem struct {
-	TLD             string // com, net,...
-	DateTimeAborted time.Time
-	LocalFilePath   string
-	DownloadURL     string
-	AttempCount     uint8 // 1 thru 3
-	StatusCode      int
-	ErrTxt          string
+	// Schedules set the interval and the time window of the TLDs
+	// that match their pattern; the first match applies. The TLDs
+	// that match none are downloaded every HoursToWaitBetweenDownloads.
+	Schedules []TLDSchedule
+
+	// HTTPClient is used for all http calls; i.e. to set a proxy,
+	// TLS settings or a custom transport. Note that a client Timeout
+	// also applies to reading the body of a (multi-gigabyte) download.
+	// If nil, a default client is used.
+	HTTPClient *http.Client
+
+	// Transport is used with the default client, when HTTPClient is nil.
+	Transport http.RoundTripper
+
+	// AccountBaseURL is the base URL of the ICANN account api
+	// (authentication); default is DefaultAccountBaseURL.
+	AccountBaseURL string
+
+	// CzdsBaseURL is the base URL of the CZDS api; default
+	// is DefaultCzdsBaseURL.
+	CzdsBaseURL string
+
+	// DiffAfterDownload compares each zone file downloaded in a session
+	// with the previous snapshot of the same TLD (see DiffZoneFiles).
+	DiffAfterDownload bool
+
+	// DiffFormat is the format of the diff files; ndjson (default) or csv.
+	DiffFormat string
+
+	// DiffDir is where the diff files are written;
+	// default is <ZoneFileDir>/diffs.
+	DiffDir string
+
+	// Retention sets how long the zone files of the TLDs that match
+	// their pattern are kept; the first match applies. The zone files
+	// of the TLDs that match none are kept. With RetentionDryRun, the
+	// files are only reported (EventZoneFilePruned); not deleted.
+	Retention       []RetentionPolicy
+	RetentionDryRun bool
+
+	// WatchApprovals checks the zone access approvals at the start of
+	// each session; TLDs that are missing from the download-links,
+	// expiring within ApprovalWarnDays (default 30), or denied are
+	// reported (see EventApprovalMissing,...). AutoExtendApprovals
+	// requests the extension of the expiring approvals.
+	WatchApprovals      bool
+	ApprovalWarnDays    int
+	AutoExtendApprovals bool
+
+	// Observers receive the events of the download lifecycle
+	// (see Event); e.g. NewConsoleObserver() for console output.
+	Observers []Observer
+
+	// Metrics, if set, is added to the observers; see NewMetrics.
+	Metrics *Metrics
+
+	// Logger receives the diagnostics of the client. If nil, a
+	// logger is created with NewLogger(os.Stderr, LogFormat, LogLevel).
+	Logger    *slog.Logger
+	LogFormat string // text (default) or json
+	LogLevel  slog.Level
 }
 
 // IcannAPI defines the structure of the IIcannAPI interface.
@@ -53,6 +162,13 @@ type IcannAPI struct {
 	// AppDataDir is the directory (on the volume) that zone files will be downloaded to.
 	AppDataDir string
 
+	// ZoneStore is where the zone files are kept; a
+	// LocalZoneStore in AppDataDir by default.
+	ZoneStore ZoneStore
+
+	// DiskSpaceWait is how long a download waits for free space.
+	DiskSpaceWait time.Duration
+
 	// UserAgent is required for all ICANN API calls; its format is:
 	// <name of you product> / <version> <comment about your product>
 	UserAgent string
@@ -63,22 +179,74 @@ type IcannAPI struct {
 	// Password is the ICANN person account password.
 	Password string
 
-	// ApprovedTLD is an array of TLDs e.g. com, net.
+	// ApprovedTLD and ExcludedTLD select the TLDs to
+	// download (glob patterns); see Config.
 	ApprovedTLD []string
+	ExcludedTLD []string
+
+	// TokenCache keeps the access token between runs.
+	TokenCache TokenCache
 
-	// Authenticated is used by callers to get the status of the authentication.
-	Authenticated bool
+	// AuthLimiter paces the authentication attempts.
+	AuthLimiter *AuthLimiter
 
-	// AccessToken is available to callers to use for Bearer in the Authorization header.
-	AccessToken JWT
+	// tokens is the source of the access tokens (see TokenSource());
+	// use it for Bearer in the Authorization header.
+	tokens *icannTokenSource
 
-	// isDirty is used to mark the first-time use of the app; so that
-	// the 120 second wait is skipped when this app is first launched.
-	isDirty bool
+	// metrics (if any) of the authentication; the rest
+	// are kept from the events (Config.Metrics).
+	metrics *Metrics
 
 	HoursToWaitBetweenDownloads int
 
-	failedDownloadQueue []failedDownloadItem
+	// HTTPClient is used for all http calls; a default
+	// client is used if nil.
+	HTTPClient *http.Client
+
+	// AccountBaseURL and CzdsBaseURL are the base URLs
+	// of the ICANN account api and the CZDS api.
+	AccountBaseURL string
+	CzdsBaseURL    string
+
+	// DiffAfterDownload, DiffFormat and DiffDir control the
+	// diff of the zone files at the end of each session.
+	DiffAfterDownload bool
+	DiffFormat        string
+	DiffDir           string
+
+	// Retention and RetentionDryRun control the pruning of
+	// the zone files at the end of each session.
+	Retention       []RetentionPolicy
+	RetentionDryRun bool
+
+	// WatchApprovals, ApprovalWarnDays and AutoExtendApprovals
+	// control the approval check at the start of each session.
+	WatchApprovals      bool
+	ApprovalWarnDays    int
+	AutoExtendApprovals bool
+
+	// Observers receive the events of the download lifecycle.
+	Observers []Observer
+
+	// Logger receives the diagnostics; slog.Default() is used if nil.
+	Logger *slog.Logger
+
+	// store is the journal of the downloads; the retry and
+	// skip decisions are made from its records.
+	store *stateStore
+
+	// linkTLDs are the TLDs of the download-links of the
+	// last approval check; lastApprovalCheck is its time.
+	linkTLDs          map[string]bool
+	lastApprovalCheck time.Time
+
+	// scheduler decides when each TLD is downloaded.
+	scheduler *scheduler
+
+	// ctx is the context passed to New; Run and all http
+	// calls stop when it is cancelled.
+	ctx context.Context
 }
 
 type JWT struct {
@@ -109,15 +277,32 @@ type ZoneFileStatus struct {
 	OriginalFileName string // e.g. com.txt.gz
 	FileLength       uint64
 	TLDType          string // e.g. com
+	ETag             string
+	LastModified     string
+}
+
+// partFileInfo is saved next to a partial download (*.part.info);
+// it is used to validate the partial file before resuming.
+type partFileInfo struct {
+	DownloadURL  string
+	ETag         string
+	LastModified string
+	FileLength   uint64
 }
 
 // TeeWriter defines the structure of the callback,
 // to get status of the download in porgress.
 type TeeWriter struct {
 	TotalDownloaded uint64
+	TotalExpected   uint64
 	File            *os.File
 	TempFilePath    string
 	FileName        string
 	TLDType         string
+	URL             string
 	StartTime       time.Time
+
+	startOffset uint64
+	lastReport  time.Time
+	icann       *IcannAPI
 }
@@ -0,0 +1,11 @@
+// (c) Kamiar Bahri
+
+//go:build !linux && !darwin && !freebsd && !windows
+
+package icannclient
+
+// diskSpace is not supported on this platform; the
+// downloads do not wait for free disk-space.
+func diskSpace(dir string) (int64, int64, error) {
+	return 0, 0, errDiskSpaceUnsupported
+}
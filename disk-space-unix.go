@@ -0,0 +1,17 @@
+// (c) Kamiar Bahri
+
+//go:build linux || darwin || freebsd
+
+package icannclient
+
+import "syscall"
+
+// diskSpace returns the bytes available to this user, and the size,
+// of the volume that holds dir.
+func diskSpace(dir string) (int64, int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), int64(st.Blocks) * int64(st.Bsize), nil
+}
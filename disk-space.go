@@ -0,0 +1,142 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDiskReserve is the part of the disk that is
+	// not used for the zone files; unless set.
+	defaultDiskReserve = "10%"
+
+	// defaultDiskSpaceWait is how long a download waits for
+	// free disk-space before it fails.
+	defaultDiskSpaceWait = time.Hour
+
+	// diskSpaceRecheck is the time between two checks
+	// of the free disk-space.
+	diskSpaceRecheck = time.Minute
+)
+
+// errDiskSpaceUnsupported is returned by diskSpace on the
+// platforms without statfs (or its equivalent).
+var errDiskSpaceUnsupported = errors.New("free disk-space is not supported on this platform")
+
+// DiskSpaceError is returned by DownloadZoneFile when there is not
+// enough free space for a zone file; after Config.DiskSpaceWait.
+type DiskSpaceError struct {
+	Path   string
+	Needed int64
+	Free   int64
+}
+
+func (e *DiskSpaceError) Error() string {
+	return fmt.Sprintf("not enough disk-space for %s: %d bytes needed; %d bytes free", e.Path, e.Needed, e.Free)
+}
+
+// parseDiskReserve parses a disk reserve; a percent of the
+// volume (e.g. 10%) or a size (e.g. 20GB).
+func parseDiskReserve(s string) (int64, float64, error) {
+
+	s = strings.TrimSpace(s)
+
+	if v, ok := strings.CutSuffix(s, "%"); ok {
+		pct, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || pct < 0 || pct >= 100 {
+			return 0, 0, fmt.Errorf("invalid disk reserve %q", s)
+		}
+		return 0, pct, nil
+	}
+
+	n, err := parseByteSize(s)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("invalid disk reserve %q", s)
+	}
+
+	return n, 0, nil
+}
+
+// freeSpace returns the space available (to this user) on the volume
+// of dir less the reserve; -1 if it cannot be known on this platform.
+func (s *LocalZoneStore) freeSpace() (int64, error) {
+
+	// the nearest directory that exists
+	dir := s.Dir
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	avail, total, err := diskSpace(dir)
+	if errors.Is(err, errDiskSpaceUnsupported) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	reserve := s.ReserveBytes
+	if r := int64(float64(total) * s.ReservePercent / 100); r > reserve {
+		reserve = r
+	}
+
+	free := avail - reserve
+	if free < 0 {
+		free = 0
+	}
+
+	return free, nil
+}
+
+// waitForSpace waits until the store has room for needed bytes;
+// rechecking every minute. A *DiskSpaceError is returned when
+// there is still no room after wait.
+func (c *CzdsAPI) waitForSpace(store ZoneStore, name string, needed int64, wait time.Duration) error {
+
+	ctx := c.icann.context()
+	deadline := time.Now().Add(wait)
+
+	for {
+		free, err := store.FreeSpace(ctx)
+		if err != nil {
+			return err
+		}
+		if free < 0 || needed < free {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return &DiskSpaceError{Path: name, Needed: needed, Free: free}
+		}
+
+		c.icann.logger().Warn("not enough disk-space, please, free some disk-space to continue",
+			"path", name, "bytes", needed, "free_gb", roundNumber(float64(free)/1024/1024/1024, 2))
+
+		d := diskSpaceRecheck
+		if left := time.Until(deadline); left < d {
+			d = left
+		}
+		if err := sleepContext(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// withDir returns a store in another directory with
+// the same reserve.
+func (s *LocalZoneStore) withDir(dir string) *LocalZoneStore {
+	return &LocalZoneStore{Dir: dir, ReserveBytes: s.ReserveBytes, ReservePercent: s.ReservePercent}
+}
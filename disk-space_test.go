@@ -0,0 +1,111 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseDiskReserve(t *testing.T) {
+
+	for _, tc := range []struct {
+		s     string
+		bytes int64
+		pct   float64
+	}{
+		{"10%", 0, 10},
+		{" 2.5 % ", 0, 2.5},
+		{"0%", 0, 0},
+		{"20GB", 20 << 30, 0},
+		{"750mb", 750 << 20, 0},
+		{"4096", 4096, 0},
+	} {
+		n, pct, err := parseDiskReserve(tc.s)
+		if err != nil || n != tc.bytes || pct != tc.pct {
+			t.Errorf("parseDiskReserve(%q) = %d, %v, %v; want %d, %v", tc.s, n, pct, err, tc.bytes, tc.pct)
+		}
+	}
+
+	for _, s := range []string{"100%", "-1%", "x%", "10XB", "-5GB", ""} {
+		if _, _, err := parseDiskReserve(s); err == nil {
+			t.Errorf("parseDiskReserve(%q) did not fail", s)
+		}
+	}
+}
+
+// The reserve (the larger of bytes and percent) is not free; a
+// dir that does not exist yet is on the volume of its parent.
+func TestLocalZoneStoreFreeSpace(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "not", "yet")
+
+	free, err := (&LocalZoneStore{Dir: dir}).freeSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free == -1 {
+		t.Skip("free disk-space is not supported on this platform")
+	}
+	if free <= 0 {
+		t.Fatalf("free = %d; want the space of the volume", free)
+	}
+
+	reserved, err := (&LocalZoneStore{Dir: dir, ReserveBytes: 1 << 20, ReservePercent: 1}).freeSpace()
+	if err != nil || reserved >= free {
+		t.Errorf("free with a reserve = %d, %v; want less than %d", reserved, err, free)
+	}
+	if n, err := (&LocalZoneStore{Dir: dir, ReserveBytes: 1 << 62}).freeSpace(); err != nil || n != 0 {
+		t.Errorf("free with a reserve larger than the disk = %d, %v; want 0", n, err)
+	}
+}
+
+// spaceStore is a ZoneStore with a set free-space.
+type spaceStore struct {
+	*LocalZoneStore
+	free int64
+	err  error
+}
+
+func (s *spaceStore) FreeSpace(ctx context.Context) (int64, error) {
+	return s.free, s.err
+}
+
+func TestWaitForSpace(t *testing.T) {
+
+	c := &CzdsAPI{icann: &IcannAPI{Logger: NewLogger(io.Discard, "", 0)}}
+
+	// room; or no known limit
+	for _, free := range []int64{1001, -1} {
+		if err := c.waitForSpace(&spaceStore{free: free}, "com", 1000, time.Hour); err != nil {
+			t.Errorf("free %d: %v", free, err)
+		}
+	}
+
+	errStat := errors.New("statfs failed")
+	if err := c.waitForSpace(&spaceStore{err: errStat}, "com", 1000, time.Hour); !errors.Is(err, errStat) {
+		t.Errorf("err = %v; want %v", err, errStat)
+	}
+
+	// no room; it gives up after the wait
+	start := time.Now()
+	err := c.waitForSpace(&spaceStore{free: 1000}, "com", 1000, 50*time.Millisecond)
+	var de *DiskSpaceError
+	if !errors.As(err, &de) || de.Needed != 1000 || de.Free != 1000 || de.Path != "com" {
+		t.Fatalf("err = %v; want a *DiskSpaceError", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > 10*time.Second {
+		t.Errorf("waited %v; want 50ms", d)
+	}
+
+	// the wait ends with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	c.icann.ctx = ctx
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := c.waitForSpace(&spaceStore{free: 0}, "com", 1000, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v; want context.Canceled", err)
+	}
+}
@@ -0,0 +1,180 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrMissingCredentials is returned when the ICANN account
+	// username or password is blank.
+	ErrMissingCredentials = errors.New("icann account username/password is required")
+
+	// ErrMissingUserAgent is returned when the user-agent is blank;
+	// ICANN API calls will fail without a proper user-agent.
+	ErrMissingUserAgent = errors.New("user-agent is required")
+
+	// ErrMissingTokenKey is returned when the token file would be
+	// encrypted with a blank key (i.e. it would be as good as plain).
+	ErrMissingTokenKey = errors.New("token key (TOKEN_KEY or SALT_PHRASE) is required to encrypt the token file")
+
+	// ErrNoDownloadLinks was returned by Run when CZDS returned an
+	// empty list of download-links.
+	//
+	// Deprecated: Run waits for the next interval instead.
+	ErrNoDownloadLinks = errors.New("unable to get download-links")
+)
+
+// AuthError is returned by Authenticate when the ICANN account
+// api rejects (or could not process) an authentication request.
+type AuthError struct {
+	// StatusCode is the http status-code of the authenticate call;
+	// zero means that the request did not get a valid response.
+	StatusCode int
+
+	// Message is the message returned by the api; if any.
+	Message string
+
+	// Err is the underlying error; if any.
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("authentication failed: status-code: %d: %v", e.StatusCode, e.Err)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("authentication failed: status-code: %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("authentication failed: status-code: %d", e.StatusCode)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary returns true if the attempt is worth repeating after the
+// auth-attempt timeout (too many requests from the same IP address,
+// a server error, or no valid response at all).
+func (e *AuthError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == 0 || e.StatusCode >= 500
+}
+
+// APIError is returned when a CZDS endpoint responds
+// with an unexpected status-code.
+type APIError struct {
+	// Op is the name of the operation e.g. getDownloadLinks.
+	Op         string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s()=> %s error %d - %s", e.Op, e.URL, e.StatusCode, e.Body)
+}
+
+// TokenError is returned when a CZDS endpoint rejects the access
+// token (401, or 403 about the token); even after the token was
+// renewed and the request replayed.
+type TokenError struct {
+	Op         string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("%s()=> %s access token rejected %d - %s", e.Op, e.URL, e.StatusCode, e.Body)
+}
+
+// ZoneAccessError is returned by DownloadZoneFile when CZDS denies
+// (403) the download of a TLD; i.e. the approval was revoked or has
+// expired. The access token is fine; other TLDs can be downloaded.
+type ZoneAccessError struct {
+	TLD        string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *ZoneAccessError) Error() string {
+	return fmt.Sprintf("access to the %s zone file is denied (%d) - %s", e.TLD, e.StatusCode, e.Body)
+}
+
+// czdsError returns the error of a CZDS response with an unexpected
+// status-code: *TokenError, *ZoneAccessError (403 of a download
+// link), or *APIError.
+func czdsError(op string, urlx string, statusCode int, hd http.Header, body []byte) error {
+
+	switch {
+	case statusCode == http.StatusUnauthorized,
+		statusCode == http.StatusForbidden && tokenError(hd):
+		return &TokenError{Op: op, URL: urlx, StatusCode: statusCode, Body: string(body)}
+
+	case statusCode == http.StatusForbidden && strings.HasSuffix(urlx, ".zone"):
+		return &ZoneAccessError{TLD: getTLDFromDownloadLink(urlx), URL: urlx, StatusCode: statusCode, Body: string(body)}
+	}
+
+	return &APIError{Op: op, URL: urlx, StatusCode: statusCode, Body: string(body)}
+}
+
+// errorStatusCode returns the http status-code of err (*APIError,
+// *TokenError, *ZoneAccessError, or *AuthError); zero if it has none.
+func errorStatusCode(err error) int {
+
+	var ae *APIError
+	var te *TokenError
+	var ze *ZoneAccessError
+	var aue *AuthError
+
+	switch {
+	case errors.As(err, &ae):
+		return ae.StatusCode
+	case errors.As(err, &te):
+		return te.StatusCode
+	case errors.As(err, &ze):
+		return ze.StatusCode
+	case errors.As(err, &aue):
+		return aue.StatusCode
+	}
+
+	return 0
+}
+
+// IntegrityError is returned by DownloadZoneFile when a downloaded
+// zone file fails a check; the file is not kept, and the download
+// is tried again.
+type IntegrityError struct {
+	Path string
+
+	// Check is the check that failed: length, gzip, or soa.
+	Check string
+	Err   error
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("%s: %s check failed: %v", e.Path, e.Check, e.Err)
+}
+
+func (e *IntegrityError) Unwrap() error {
+	return e.Err
+}
+
+// ZoneParseError is returned by ZoneReader for an entry
+// that cannot be parsed.
+type ZoneParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ZoneParseError) Error() string {
+	return fmt.Sprintf("zone file line %d: %v", e.Line, e.Err)
+}
+
+func (e *ZoneParseError) Unwrap() error {
+	return e.Err
+}
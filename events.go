@@ -0,0 +1,211 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+// EventDownloadStarted has the size (of the HEAD request); a download
+// that fails (or is found unchanged) before its GET has none.
+const (
+	EventAuthSucceeded     EventType = "auth-succeeded"
+	EventAuthFailed        EventType = "auth-failed"
+	EventLinksFetched      EventType = "links-fetched"
+	EventDownloadStarted   EventType = "download-started"
+	EventDownloadProgress  EventType = "download-progress"
+	EventDownloadCompleted EventType = "download-completed"
+	EventDownloadFailed    EventType = "download-failed"
+	EventDownloadUnchanged EventType = "download-unchanged"
+	EventCycleFinished     EventType = "cycle-finished"
+	EventIdle              EventType = "idle"
+	EventZoneFilePruned    EventType = "zone-file-pruned"
+
+	// approval check (Config.WatchApprovals)
+	EventApprovalMissing  EventType = "approval-missing"
+	EventApprovalExpiring EventType = "approval-expiring"
+	EventApprovalDenied   EventType = "approval-denied"
+	EventApprovalExtended EventType = "approval-extended"
+	EventApprovalsChecked EventType = "approvals-checked"
+)
+
+// progressInterval is the minimum time between two
+// EventDownloadProgress events of the same download.
+const progressInterval = time.Second
+
+// Event is sent to the observers at each point of the download
+// lifecycle; only the fields relevant to the Type are set.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	TLD           string
+	URL           string
+	LocalFilePath string
+	StatusCode    int
+
+	// Bytes is the number of bytes on disk (including resumed
+	// bytes); TotalBytes is the Content-Length of the zone file.
+	Bytes          uint64
+	TotalBytes     uint64
+	BytesPerSecond float64
+	ETA            time.Duration
+	Elapsed        time.Duration
+
+	// Attempt is the number of failed attempts of a download;
+	// Queued is true if the download will be tried again.
+	Attempt int
+	Queued  bool
+
+	// Links is the number of download-links; Downloaded and Failed
+	// are the counts of a session (EventCycleFinished).
+	Links      int
+	Downloaded int
+	Failed     int
+
+	// UnchangedSince is the date (YYYY-MM-DD) of the zone file
+	// that a new date is linked to (EventDownloadUnchanged).
+	UnchangedSince string
+
+	// FailedQueue is the number of downloads waiting to be retried
+	// (EventDownloadFailed, EventCycleFinished).
+	FailedQueue int
+
+	// DryRun is true if the zone file was not deleted; as
+	// Config.RetentionDryRun is set (EventZoneFilePruned).
+	DryRun bool
+
+	// NextRun is the time the next session starts (EventIdle).
+	NextRun time.Time
+
+	// TokenExpires is the expiry of the new access token
+	// (EventAuthSucceeded).
+	TokenExpires time.Time
+
+	// RequestID, RequestStatus and Expires are of the access
+	// request of a TLD (EventApproval*); Missing, Expiring and
+	// Denied are the counts of EventApprovalsChecked.
+	RequestID     string
+	RequestStatus string
+	Expires       time.Time
+	Missing       int
+	Expiring      int
+	Denied        int
+
+	Err error
+}
+
+// Observer receives the events of the client. OnEvent is called
+// synchronously from the goroutine doing the work; so it should
+// return quickly.
+type Observer interface {
+	OnEvent(e Event)
+}
+
+// ObserverFunc adapts a function to the Observer interface.
+type ObserverFunc func(e Event)
+
+// OnEvent calls f(e).
+func (f ObserverFunc) OnEvent(e Event) {
+	f(e)
+}
+
+// emit writes e to the logger and sends it to all observers.
+func (i *IcannAPI) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	i.logEvent(e)
+
+	for _, o := range i.Observers {
+		o.OnEvent(e)
+	}
+}
+
+// ConsoleObserver writes the events to the console; the
+// download progress is redrawn on the same line. Progress
+// is not written if Out is not a terminal.
+type ConsoleObserver struct {
+	// Out is where the events are written; default is os.Stdout.
+	Out io.Writer
+}
+
+// NewConsoleObserver returns an observer that writes to stdout.
+func NewConsoleObserver() *ConsoleObserver {
+	return &ConsoleObserver{Out: os.Stdout}
+}
+
+// OnEvent writes e to the console.
+func (co *ConsoleObserver) OnEvent(e Event) {
+
+	out := co.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	// for formatting the downloaded bytes
+	mp := message.NewPrinter(language.English)
+
+	switch e.Type {
+	case EventAuthSucceeded:
+		fmt.Fprintln(out, "Authenticated: true")
+	case EventAuthFailed:
+		fmt.Fprintln(out, "authentication failed:", e.Err)
+	case EventLinksFetched:
+		fmt.Fprintf(out, "download-links: %d\n", e.Links)
+	case EventDownloadStarted:
+		fmt.Fprintf(out, "downloading '%s' as '%s' (%s mb)\n", e.URL, e.LocalFilePath, mp.Sprintf("%d", e.TotalBytes/1024/1024))
+	case EventDownloadProgress:
+		if !isTerminal(out) {
+			return
+		}
+		fmt.Fprintf(out, "\r\t%s %v mb elapsed: %v eta: %v ", e.TLD, mp.Sprintf("%d", e.Bytes/1024/1024),
+			formatDuration(e.Elapsed), formatDuration(e.ETA))
+	case EventDownloadCompleted:
+		if isTerminal(out) {
+			fmt.Fprintln(out, "")
+		}
+		fmt.Fprintf(out, "%s downloaded: %s mb in %v\n", e.TLD, mp.Sprintf("%d", e.Bytes/1024/1024), formatDuration(e.Elapsed))
+	case EventDownloadUnchanged:
+		fmt.Fprintf(out, "%s unchanged since %s\n", e.TLD, e.UnchangedSince)
+	case EventDownloadFailed:
+		if isTerminal(out) {
+			fmt.Fprintln(out, "")
+		}
+		fmt.Fprintln(out, " c.DownloadZoneFile()=>", e.TLD, e.Err)
+	case EventCycleFinished:
+		fmt.Fprintf(out, "session finished; downloaded: %d failed: %d\n", e.Downloaded, e.Failed)
+	case EventApprovalMissing:
+		fmt.Fprintf(out, "%s is not in the download-links (request status: %s)\n", e.TLD, statusOrNone(e.RequestStatus))
+	case EventApprovalExpiring:
+		fmt.Fprintf(out, "%s approval expires on %s\n", e.TLD, e.Expires.Format("2006-01-02"))
+	case EventApprovalDenied:
+		fmt.Fprintf(out, "%s access request is %s\n", e.TLD, e.RequestStatus)
+	case EventApprovalExtended:
+		fmt.Fprintf(out, "%s extension requested (expires on %s)\n", e.TLD, e.Expires.Format("2006-01-02"))
+	case EventZoneFilePruned:
+		if e.DryRun {
+			fmt.Fprintf(out, "%s would be deleted (%s mb)\n", e.LocalFilePath, mp.Sprintf("%d", e.Bytes/1024/1024))
+		} else {
+			fmt.Fprintf(out, "%s deleted (%s mb)\n", e.LocalFilePath, mp.Sprintf("%d", e.Bytes/1024/1024))
+		}
+	case EventIdle:
+		fmt.Fprintf(out, "download will resume at %s (in %s)\n", e.NextRun.Format(time.RFC1123), formatDuration(time.Until(e.NextRun)))
+	}
+}
+
+func statusOrNone(s string) string {
+	if s == "" {
+		return "never requested"
+	}
+	return s
+}
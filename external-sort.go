@@ -0,0 +1,204 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultSortChunkBytes is the amount of lines (in bytes) kept in
+// memory before a sorted chunk is written to a temp file.
+const defaultSortChunkBytes = 64 * 1024 * 1024
+
+// externalSorter sorts lines that do not fit in memory; lines are
+// sorted in chunks of maxBytes, written to temp files, and merged
+// when read back.
+type externalSorter struct {
+	tempDir  string
+	maxBytes int
+
+	lines  []string
+	size   int
+	chunks []string
+}
+
+// lineIterator returns the sorted lines one at a time;
+// io.EOF at the end. Close releases the temp files (if
+// any); it is safe to call more than once.
+type lineIterator interface {
+	Next() (string, error)
+	Close()
+}
+
+func newExternalSorter(tempDir string, maxBytes int) *externalSorter {
+	if maxBytes <= 0 {
+		maxBytes = defaultSortChunkBytes
+	}
+	return &externalSorter{tempDir: tempDir, maxBytes: maxBytes}
+}
+
+// Add adds a line (without the new-line character).
+func (s *externalSorter) Add(line string) error {
+	s.lines = append(s.lines, line)
+	s.size += len(line) + 16
+
+	if s.size >= s.maxBytes {
+		return s.writeChunk()
+	}
+
+	return nil
+}
+
+// Sort returns an iterator of all the lines added; in order. The
+// caller must Close the iterator.
+func (s *externalSorter) Sort() (it lineIterator, err error) {
+
+	if len(s.chunks) == 0 {
+		sort.Strings(s.lines)
+		return &sliceLineIterator{lines: s.lines}, nil
+	}
+
+	if len(s.lines) > 0 {
+		if err := s.writeChunk(); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &mergeLineIterator{}
+	defer func() {
+		if err != nil {
+			m.Close()
+		}
+	}()
+
+	for _, fp := range s.chunks {
+		f, err := os.Open(fp)
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), maxZoneLineLength)
+
+		m.files = append(m.files, f)
+		if sc.Scan() {
+			m.h = append(m.h, mergeItem{line: sc.Text(), sc: sc})
+		} else if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	heap.Init(&m.h)
+
+	return m, nil
+}
+
+// Close removes the temp files.
+func (s *externalSorter) Close() {
+	for _, fp := range s.chunks {
+		os.Remove(fp)
+	}
+	s.chunks = nil
+	s.lines = nil
+}
+
+// writeChunk sorts the lines in memory and writes them to a temp file.
+func (s *externalSorter) writeChunk() error {
+
+	sort.Strings(s.lines)
+
+	f, err := os.CreateTemp(s.tempDir, "zone-sort-*.tmp")
+	if err != nil {
+		return err
+	}
+	s.chunks = append(s.chunks, f.Name())
+
+	w := bufio.NewWriterSize(f, 1024*1024)
+	for _, line := range s.lines {
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	s.lines = s.lines[:0]
+	s.size = 0
+
+	return nil
+}
+
+type sliceLineIterator struct {
+	lines []string
+	pos   int
+}
+
+func (it *sliceLineIterator) Next() (string, error) {
+	if it.pos >= len(it.lines) {
+		return "", io.EOF
+	}
+	it.pos++
+	return it.lines[it.pos-1], nil
+}
+
+func (it *sliceLineIterator) Close() {}
+
+// mergeLineIterator merges the sorted chunk files (k-way merge).
+type mergeLineIterator struct {
+	h     mergeHeap
+	files []*os.File
+}
+
+func (m *mergeLineIterator) Next() (string, error) {
+	if len(m.h) == 0 {
+		m.Close()
+		return "", io.EOF
+	}
+
+	line := m.h[0].line
+	sc := m.h[0].sc
+
+	if sc.Scan() {
+		m.h[0].line = sc.Text()
+		heap.Fix(&m.h, 0)
+	} else {
+		if err := sc.Err(); err != nil {
+			return "", err
+		}
+		heap.Pop(&m.h)
+	}
+
+	return line, nil
+}
+
+func (m *mergeLineIterator) Close() {
+	for _, f := range m.files {
+		f.Close()
+	}
+	m.files = nil
+}
+
+type mergeItem struct {
+	line string
+	sc   *bufio.Scanner
+}
+
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Less(i, j int) bool { return strings.Compare(h[i].line, h[j].line) < 0 }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)        { *h = append(*h, x.(mergeItem)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
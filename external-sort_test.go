@@ -0,0 +1,111 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"testing"
+)
+
+// sortLines adds lines to s and returns them back; sorted.
+func sortLines(t *testing.T, s *externalSorter, lines []string) []string {
+	t.Helper()
+
+	for _, line := range lines {
+		if err := s.Add(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, err := s.Sort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	var sorted []string
+	for {
+		line, err := it.Next()
+		if err == io.EOF {
+			return sorted
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sorted = append(sorted, line)
+	}
+}
+
+// Lines that do not fit in maxBytes are sorted in chunks (temp
+// files) and merged; duplicates are kept.
+func TestExternalSorterChunks(t *testing.T) {
+
+	dir := t.TempDir()
+
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf("domain-%x.com.\tNS\tns%d.example.net.", i*7919%1000, i%3))
+	}
+	lines = append(lines, lines[10], lines[500])
+
+	// about 10 lines per chunk
+	s := newExternalSorter(dir, 500)
+	got := sortLines(t, s, lines)
+
+	if len(s.chunks) < 50 {
+		t.Errorf("chunks = %d; want the lines sorted in many chunks", len(s.chunks))
+	}
+
+	want := append([]string(nil), lines...)
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("the merged lines are not in order (%d lines; want %d)", len(got), len(want))
+	}
+
+	s.Close()
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d temp files are left behind", len(files))
+	}
+}
+
+// Lines that fit in memory are not written to a temp file.
+func TestExternalSorterInMemory(t *testing.T) {
+
+	dir := t.TempDir()
+	s := newExternalSorter(dir, 0)
+	defer s.Close()
+
+	got := sortLines(t, s, []string{"c", "a", "b", "a"})
+	if fmt.Sprint(got) != "[a a b c]" {
+		t.Errorf("sorted = %v", got)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 || len(s.chunks) != 0 {
+		t.Errorf("%d temp files; want none", len(files))
+	}
+}
+
+// The heap yields the smallest line of the chunks; a chunk
+// that runs out is dropped, the others go on.
+func TestMergeLineIterator(t *testing.T) {
+
+	s := newExternalSorter(t.TempDir(), 0)
+	defer s.Close()
+
+	for _, chunk := range [][]string{{"h", "a", "g", "d"}, {"b"}, {"f", "c", "e"}} {
+		s.lines = chunk
+		if err := s.writeChunk(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the lines not written yet are the last chunk
+	got := sortLines(t, s, []string{"i", "a"})
+	if fmt.Sprint(got) != "[a a b c d e f g h i]" {
+		t.Errorf("merged = %v", got)
+	}
+	if len(s.chunks) != 4 {
+		t.Errorf("chunks = %d; want 4", len(s.chunks))
+	}
+}
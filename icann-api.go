@@ -3,63 +3,157 @@ package icannclient
 
 import (
 	"bytes"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
+	"path/filepath"
 	"time"
 )
 
 // ICannAPI interface performs the basic funtions to interact
 // with the ICANN's API.
 type IIcannAPI interface {
-	Authenticate()
+	Authenticate() error
 	HTTPExec(method string, url string, hd http.Header, data []byte) HTTPResult
 	GetCommonHeaders() http.Header
-	Run()
+	Run() error
+	TokenSource() TokenSource
+	Authenticated() bool
 
 	accessTokenExpired() bool
-	waitForAuthAttemptTimeout()
-	writeAccessTokenToDisk()
-	getAccessTokenFromDisk()
+	waitForAuthAttemptTimeout(ctx context.Context) error
+	saveAccessToken(t JWT) error
+	loadAccessToken() JWT
 }
 
-// Run renews the access token every 23 hours
-func (i *IcannAPI) Run() {
+// Run renews the access token Config.TokenRefreshMargin before it
+// expires. It returns when the context passed to New is cancelled, or
+// with the *AuthError of an authentication that is not worth repeating
+// (see AuthError.Temporary).
+func (i *IcannAPI) Run() error {
+
+	ctx := i.context()
 
 	for {
-		acceessTokenExpired := i.accessTokenExpired()
+		// the token is renewed if it is due; failures are
+		// retried on the next round, unless the context is done
+		// or the failure is not temporary (e.g. a bad password);
+		// the token may still be good meanwhile.
+		_, err := i.tokens.Token(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			err = i.tokens.lastError()
+		}
+		var ae *AuthError
+		if errors.As(err, &ae) && !ae.Temporary() {
+			return err
+		}
 
-		if acceessTokenExpired {
-			i.Authenticated = false
-			i.Authenticate()
+		// sleep until the token is due for renewal; a failed
+		// renewal is retried shortly (authenticate waits for
+		// the rate-limit).
+		wait := time.Until(i.tokens.refreshAt())
+		if wait < time.Second {
+			wait = time.Second
 		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
 
-		if i.isDirty {
-			// wait just over two minutes
-			time.Sleep(126 * time.Second)
+// context returns the context passed to New; or
+// context.Background() if there was none.
+func (i *IcannAPI) context() context.Context {
+	if i.ctx == nil {
+		return context.Background()
+	}
+	return i.ctx
+}
 
-		} else {
-			i.isDirty = true
-		}
+// getHTTPClient returns the client set by the caller;
+// or a default client.
+func (i *IcannAPI) getHTTPClient() *http.Client {
+	if i.HTTPClient != nil {
+		return i.HTTPClient
+	}
+	return &http.Client{}
+}
+
+// getAuthenticateURL returns the authenticate endpoint
+// of the ICANN account api.
+func (i *IcannAPI) getAuthenticateURL() string {
+	baseURL := i.AccountBaseURL
+	if baseURL == "" {
+		baseURL = DefaultAccountBaseURL
+	}
+	return baseURL + authenticatePath
+}
+
+// getDownloadLinksURL returns the CZDS endpoint
+// that lists the download-links.
+func (i *IcannAPI) getDownloadLinksURL() string {
+	return i.getCzdsURL(czdsAPIDownloadLinksPath)
+}
+
+// getCzdsURL returns the url of a path on the CZDS api.
+func (i *IcannAPI) getCzdsURL(path string) string {
+	baseURL := i.CzdsBaseURL
+	if baseURL == "" {
+		baseURL = DefaultCzdsBaseURL
+	}
+	return baseURL + path
+}
+
+// ensureAccessToken authenticates only if the access token
+// (in memory or on disk) has expired.
+func (i *IcannAPI) ensureAccessToken() error {
+	_, err := i.tokens.Token(i.context())
+	return err
+}
+
+// TokenSource returns the source of the access tokens; it is
+// shared by IcannAPI and CzdsAPI, and safe for concurrent use.
+func (i *IcannAPI) TokenSource() TokenSource {
+	return i.tokens
+}
+
+// Authenticated returns true if there is an access token
+// that has not expired.
+func (i *IcannAPI) Authenticated() bool {
+	return !i.accessTokenExpired()
+}
+
+// authFailed sends EventAuthFailed and returns err.
+func (i *IcannAPI) authFailed(err error) error {
+	var statusCode int
+	if ae, ok := err.(*AuthError); ok {
+		statusCode = ae.StatusCode
 	}
+	i.emit(Event{Type: EventAuthFailed, URL: i.getAuthenticateURL(), StatusCode: statusCode, Err: err})
+
+	return err
 }
 
 // waitForAuthAttemptTimeout halts the system until
 // it reaches an appropiate time to make another auth attmept.
-// The limit is 8 attmept in 5 minutes per IP address.
-func (i *IcannAPI) waitForAuthAttemptTimeout() {
+// The limit is 8 attmept in 5 minutes per IP address (see AuthLimiter).
+func (i *IcannAPI) waitForAuthAttemptTimeout(ctx context.Context) error {
+	return i.authLimiter().Wait(ctx)
+}
 
-	if mLastAuthenticationAttempt.Year() == 1 {
-		// first time since the app has started
-		return
+// authLimiter returns the AuthLimiter; or the one
+// shared by the process if there is none.
+func (i *IcannAPI) authLimiter() *AuthLimiter {
+	if i.AuthLimiter != nil {
+		return i.AuthLimiter
 	}
-
-	// it's safer to wait the whole 2 minutes
-	time.Sleep(2 * time.Minute)
+	return defaultAuthLimiter
 }
 
 // GetCommonHeaders gets the headers required by icann api.
@@ -72,132 +166,148 @@ func (i *IcannAPI) GetCommonHeaders() http.Header {
 	return hd
 }
 
-// accessTokenExpired returns true, if the issue-date of
-// the access token a less than 24 hours.
+// accessTokenExpired returns true, if there is no access
+// token (in memory or in the TokenCache) or it has expired.
 func (i *IcannAPI) accessTokenExpired() bool {
-
-	if i.AccessToken.Token == "" || i.AccessToken.DateTimeIssued.Year() < 2000 {
-		i.getAccessTokenFromDisk()
-	}
-
-	// check for empty time
-	if i.AccessToken.DateTimeIssued.Year() < time.Now().Year() {
-		return true
-	}
-
-	return i.AccessToken.DateTimeIssued.Add(23 * time.Hour).Before(time.Now())
+	return !i.tokens.current().valid(time.Now())
 }
 
-// writeAccessTokenToDisk transforms i.AccessToken into hex
-// and saves it to disk (tokenFileName in i.AppDataDir directory).
-func (i *IcannAPI) writeAccessTokenToDisk() {
-
-	if !FileOrDirExists(i.AppDataDir) {
-		log.Fatal("unable to access appdata path")
-		return
+// tokenCache returns the TokenCache (set by New from Config). An
+// IcannAPI made otherwise has none; the token cannot be saved then
+// (ErrMissingTokenKey), rather than being saved with no key.
+func (i *IcannAPI) tokenCache() TokenCache {
+	if i.TokenCache != nil {
+		return i.TokenCache
 	}
+	return NewFileTokenCache(filepath.Join(i.AppDataDir, tokenFileName), "")
+}
 
-	tokenFilePath := fmt.Sprintf("%s/%s", i.AppDataDir, tokenFileName)
-	b, _ := json.Marshal(i.AccessToken)
-	b = []byte(hex.EncodeToString(b))
-	os.WriteFile(tokenFilePath, b, os.ModePerm)
+// saveAccessToken saves t in the TokenCache.
+func (i *IcannAPI) saveAccessToken(t JWT) error {
+	return i.tokenCache().Save(i.context(), t)
 }
 
-// getAccessTokenFromDisk reads the tokenFileName from i.AppDataDir
-// (if exists) and transforms its content into i.AccessToken.
-func (i *IcannAPI) getAccessTokenFromDisk() {
+// loadAccessToken returns the token in the TokenCache; a zero
+// JWT if there is none (or it cannot be read).
+func (i *IcannAPI) loadAccessToken() JWT {
 
-	tokenFilePath := fmt.Sprintf("%s/%s", i.AppDataDir, tokenFileName)
-	if !FileOrDirExists(tokenFilePath) {
-		return
+	t, err := i.tokenCache().Load(i.context())
+	if err != nil {
+		i.logger().Warn("unable to read the cached access token", "error", err)
+		return JWT{}
 	}
-	b, _ := os.ReadFile(tokenFilePath)
-	b, _ = hex.DecodeString(string(b))
 
-	json.Unmarshal(b, &i.AccessToken)
+	return t
 }
 
 // Authenticate calls the authenticate and retreives an
 // access code, which can be used by the ICzdsAPI interface.
-func (i *IcannAPI) Authenticate() {
+// A rejected attempt is returned as *AuthError. Concurrent
+// calls share one attempt (see TokenSource).
+func (i *IcannAPI) Authenticate() error {
+	_, err := i.tokens.Refresh(i.context())
+	return err
+}
 
-	actExp := i.accessTokenExpired()
+// authenticate makes an authentication attempt; only called
+// by the TokenSource. ctx is of the caller that needs the token.
+func (i *IcannAPI) authenticate(ctx context.Context) (JWT, error) {
 
-	if !actExp {
-		// token still good? test the token
-		// hd := i.GetCommonHeaders()
-		// hd.Add("Authorization", fmt.Sprintf("Bearer %s", i.AccessToken.Token))
-		// res := i.HTTPExec(GET, czdsAPIDownloadLinksURL, hd, nil)
-		// if res.StatusCode == 200 {
-		i.Authenticated = true
-		// 	return
-		// }
-	}
+	var t JWT
 
-	i.waitForAuthAttemptTimeout()
+	if err := i.waitForAuthAttemptTimeout(ctx); err != nil {
+		return t, err
+	}
 
 	data := []byte(fmt.Sprintf(`{"username":"%s", "password":"%s"}`, i.UserName, i.Password))
 	hd := i.GetCommonHeaders()
-	res := i.HTTPExec(POST, authenticateBaseURL, hd, data)
-	mLastAuthenticationAttempt = time.Now()
+	res := i.httpExec(ctx, POST, i.getAuthenticateURL(), hd, data, false)
+	if ctx.Err() != nil {
+		// cancelled; the attempt stays counted (by the AuthLimiter),
+		// as the request may have reached ICANN. It is not a failure
+		// though; there is no backoff.
+		return t, ctx.Err()
+	}
+	if err := i.authLimiter().Done(ctx, res.StatusCode, res.ResponseHeaders); err != nil {
+		i.logger().Warn("unable to save the auth-attempt", "error", err)
+	}
+	i.metrics.authAttempt(res.StatusCode)
 
 	// too many authentication attempts from the same IP address
 	if res.StatusCode == http.StatusTooManyRequests {
-		// not much can be done until ~2 minutes has elapsed,
-		i.Authenticated = false
-		return
+		// not much can be done until Retry-After has elapsed
+		// (the AuthLimiter waits for it).
+		return t, i.authFailed(&AuthError{StatusCode: res.StatusCode, Message: string(res.ResponseBody)})
 
 	} else if res.StatusCode == 0 {
 		// status-code zero in this case does not necessarily mean
 		// that the authentication was rejected; it would rather mean
 		// the icann api could make sense of the information passed to
-		// it (i.e. hearders were not read). So, display the message
-		// and try again. This func will be called again in 2 minutes.
-		i.Authenticated = false
-		log.Println("authentication failed: status-code: 0; trying again in 2 min...")
-		return
+		// it (i.e. hearders were not read). So, the caller should
+		// try again; after the backoff of the AuthLimiter.
+		return t, i.authFailed(&AuthError{StatusCode: 0, Err: res.Error})
 	}
 
 	if res.StatusCode != http.StatusOK {
 		// whether api site was unavailable or authenticaton failed, it's a
 		// good idea to bail out.
-		log.Fatal("authentication failed status-code:", res.StatusCode)
+		return t, i.authFailed(&AuthError{StatusCode: res.StatusCode, Message: string(res.ResponseBody)})
 	}
 
 	var autRes autResult
 	err := json.Unmarshal(res.ResponseBody, &autRes)
 	if err != nil {
-		// don't bail out; just display the error.
-		// as we could be in a middle of a long-running download
-		fmt.Println("")
-		log.Println(err)
-		return
+		// the token (if any) is still usable; as we could
+		// be in a middle of a long-running download.
+		return t, i.authFailed(&AuthError{StatusCode: res.StatusCode, Err: err})
 	}
 
 	if autRes.Message == "Authentication Successful" {
-		i.Authenticated = true
-		i.AccessToken.Token = autRes.AccessToken
-		i.AccessToken.DateTimeIssued = time.Now()
-		i.AccessToken.DateTimeExpires = time.Now().Add(23 * time.Hour)
+		t.Token = autRes.AccessToken
+		t.DateTimeIssued = time.Now()
+		t.DateTimeExpires = t.DateTimeIssued.Add(tokenLifetime)
+		t = t.withClaims()
+
+		if err := i.saveAccessToken(t); err != nil {
+			return t, err
+		}
 
-		i.writeAccessTokenToDisk()
+		i.emit(Event{Type: EventAuthSucceeded, URL: i.getAuthenticateURL(), StatusCode: res.StatusCode,
+			TokenExpires: t.DateTimeExpires})
 
 	} else {
 		// unlikely, but still account for this (status-cocde=200 and
 		// success message missing)
-		log.Fatal("authentication failed:", autRes.Message)
+		return t, i.authFailed(&AuthError{StatusCode: res.StatusCode, Message: autRes.Message})
 	}
 
-	return
+	return t, nil
 }
 
 // HTTPExec is wrappter to make http calls.
 func (i *IcannAPI) HTTPExec(method string, urlx string, hd http.Header, data []byte) HTTPResult {
+	return i.httpExec(i.context(), method, urlx, hd, data, false)
+}
+
+// czdsExec is HTTPExec for the CZDS api; the bearer token is
+// added, and renewed if it is rejected (see authTransport).
+func (i *IcannAPI) czdsExec(method string, urlx string, data []byte) HTTPResult {
+	return i.httpExec(i.context(), method, urlx, i.GetCommonHeaders(), data, true)
+}
+
+func (i *IcannAPI) httpExec(ctx context.Context, method string, urlx string, hd http.Header, data []byte, bearer bool) HTTPResult {
 
 	var res HTTPResult
 
-	client := &http.Client{}
-	req, _ := http.NewRequest(method, urlx, bytes.NewBuffer([]byte(data)))
+	client := i.getHTTPClient()
+	if bearer {
+		client = i.czdsClient()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, urlx, bytes.NewBuffer([]byte(data)))
+	if err != nil {
+		res.Error = err
+		return res
+	}
 
 	req.Header = hd
 
@@ -209,7 +319,10 @@ func (i *IcannAPI) HTTPExec(method string, urlx string, hd http.Header, data []b
 	body, _ := io.ReadAll(resp.Body)
 	req.Body.Close()
 	resp.Body.Close()
-	client.CloseIdleConnections()
+
+	if i.HTTPClient == nil {
+		client.CloseIdleConnections()
+	}
 
 	res.ResponseHeaders = resp.Header
 	res.StatusCode = resp.StatusCode
@@ -0,0 +1,49 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kambahr/go-icann-api-client/czdstest"
+)
+
+// A renewal that is rejected (e.g. the password was changed) is not
+// retried; Run returns the *AuthError, though the token is still good.
+func TestRunStopsOnRejectedRenewal(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	// due for renewal right away (TokenRefreshMargin is 1h)
+	s.TokenLifetime = 30 * time.Minute
+
+	icn, err := New(context.Background(), testConfig(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddUser("u", "changed")
+
+	done := make(chan error, 1)
+	go func() { done <- icn.IcannAPI.Run() }()
+
+	select {
+	case err = <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	var ae *AuthError
+	if !errors.As(err, &ae) || ae.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Run = %v; want a 401 *AuthError", err)
+	}
+	if !icn.IcannAPI.Authenticated() {
+		t.Error("the token that is still good was dropped")
+	}
+	if n := s.AuthAttempts(); n != 2 {
+		t.Errorf("auth attempts = %d; want 2", n)
+	}
+}
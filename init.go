@@ -2,8 +2,11 @@
 package icannclient
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -11,54 +14,146 @@ import (
 	"time"
 )
 
+// New creates a new instance of the icann client from cnf.
+// Unlike NewIcannAPIClient, it does not wait, does not start
+// any goroutine, and returns an error instead of exiting the
+// process. The access token is obtained (or read from disk)
+// before returning; callers then call CzdsAPI.Run, which
+// returns when ctx is cancelled.
+func New(ctx context.Context, cnf Config) (*IcannClient, error) {
+
+	if err := cnf.validate(); err != nil {
+		return nil, err
+	}
+
+	if !FileOrDirExists(cnf.ZoneFileDir) {
+		if err := os.MkdirAll(cnf.ZoneFileDir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	if cnf.Metrics != nil {
+		cnf.Observers = append(append([]Observer{}, cnf.Observers...), cnf.Metrics)
+	}
+
+	var icn IcannClient
+
+	// Initialize the IcannAPI interface
+	api := newIcannAPI(ctx, cnf)
+	icn.IcannAPI = api
+
+	// CzdsAPI expands the IcannAPI interface with more functionaliy; it
+	// shares the same IcannAPI instance (and so the same access token).
+	icn.CzdsAPI = &CzdsAPI{api}
+
+	sch, err := newScheduler(cnf.ZoneFileDir, time.Duration(cnf.HoursToWaitBetweenDownloads)*time.Hour, cnf.Schedules)
+	if err != nil {
+		return nil, err
+	}
+	icn.CzdsAPI.ICANN().scheduler = sch
+
+	store, err := openStateStore(cnf.ZoneFileDir)
+	if err != nil {
+		return nil, err
+	}
+	icn.CzdsAPI.ICANN().store = store
+
+	if err := icn.CzdsAPI.ICANN().ensureAccessToken(); err != nil {
+		return nil, err
+	}
+
+	return &icn, nil
+}
+
 // NewIcannAPIClient creates a new instance of the icann interface.
-// It runs the authentication immediately.
+// It runs the authentication immediately. The configuration is read
+// from the environment; any error is fatal.
 func NewIcannAPIClient() *IcannClient {
 
 	// Set the env. right away. This encrypts the plain
 	// text in the env file; if any.
-	cnf := setEnv()
-
-	tick := time.Tick(time.Second)
-	for i := 15; i >= 1; i-- {
-		<-tick
-		fmt.Printf("\rdownload will start is %02d seconds ", i)
+	cnf, err := setEnv()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	var icn IcannClient
-	var authToken JWT
-	var authenticated bool
-
-	var fdArry []failedDownloadItem
+	// the console is the main output of this client; only
+	// warnings and errors are logged (unless LOG_LEVEL is set).
+	cnf.Observers = append(cnf.Observers, NewConsoleObserver())
+	if os.Getenv("LOG_LEVEL") == "" {
+		cnf.LogLevel = slog.LevelWarn
+	}
 
-	// Initialize the IcannAPI interface
-	icn.IcannAPI = &IcannAPI{cnf.ZoneFileDir, cnf.UserAgent,
-		cnf.IcannAccountUserName, cnf.IcannAccountPassword, cnf.ApprovedTLD,
-		authenticated, authToken, false, cnf.HoursToWaitBetweenDownloads, fdArry}
+	// without a key (i.e. an env of an older version) the
+	// token is not saved; it is obtained on each start.
+	if cnf.TokenKey == "" {
+		log.Println("warning: TOKEN_KEY (or SALT_PHRASE) is not set; the access token is kept in memory only")
+		cnf.TokenCache = NewMemoryTokenCache()
+	}
 
-	// CzdsAPI expands the IcannAPI interface with more functionaliy; so its IcannAPI instance
-	// must be initialized accordingly.
-	icn.CzdsAPI = &CzdsAPI{
-		&IcannAPI{cnf.ZoneFileDir, cnf.UserAgent,
-			cnf.IcannAccountUserName, cnf.IcannAccountPassword, cnf.ApprovedTLD,
-			authenticated, authToken, false, cnf.HoursToWaitBetweenDownloads, fdArry},
+	tick := time.Tick(time.Second)
+	for i := 15; i >= 1; i-- {
+		<-tick
+		if isTerminal(os.Stdout) {
+			fmt.Printf("\rdownload will start is %02d seconds ", i)
+		}
 	}
 
 	// Authenticate on the first run; after that --
 	// the auth token is renewed periodically. If
 	// authentiation is not successfull there will
 	// be a fatal error here.
-	icn.CzdsAPI.ICANN().Authenticate()
+	icn, err := New(context.Background(), cnf)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// send the results to the screen, in case the console
-	// is being watched; this line will be replaced shortly
-	// after it's displayed.
-	ConsoleClearLastLine()
-	fmt.Println("Authenticated:", icn.CzdsAPI.ICANN().Authenticated)
+	go fireAPIRun(icn)
 
-	go fireAPIRun(&icn)
+	return icn
+}
 
-	return &icn
+// ConfigFromEnv reads the configuration from the environment
+// variables. The icann.env file in the install-path (if exists)
+// is read first; its plain-text values are encrypted. The
+// configuration is validated (and completed) by New.
+func ConfigFromEnv() (Config, error) {
+	return setEnv()
+}
+
+// newIcannAPI initializes an IcannAPI from cnf.
+func newIcannAPI(ctx context.Context, cnf Config) *IcannAPI {
+	i := &IcannAPI{
+		AppDataDir:                  cnf.ZoneFileDir,
+		ZoneStore:                   cnf.ZoneStore,
+		DiskSpaceWait:               cnf.DiskSpaceWait,
+		TokenCache:                  cnf.TokenCache,
+		AuthLimiter:                 cnf.AuthLimiter,
+		UserAgent:                   cnf.UserAgent,
+		UserName:                    cnf.IcannAccountUserName,
+		Password:                    cnf.IcannAccountPassword,
+		ApprovedTLD:                 cnf.ApprovedTLD,
+		ExcludedTLD:                 cnf.ExcludedTLD,
+		HoursToWaitBetweenDownloads: cnf.HoursToWaitBetweenDownloads,
+		HTTPClient:                  cnf.HTTPClient,
+		AccountBaseURL:              cnf.AccountBaseURL,
+		CzdsBaseURL:                 cnf.CzdsBaseURL,
+		DiffAfterDownload:           cnf.DiffAfterDownload,
+		DiffFormat:                  cnf.DiffFormat,
+		DiffDir:                     cnf.DiffDir,
+		Retention:                   cnf.Retention,
+		RetentionDryRun:             cnf.RetentionDryRun,
+		WatchApprovals:              cnf.WatchApprovals,
+		ApprovalWarnDays:            cnf.ApprovalWarnDays,
+		AutoExtendApprovals:         cnf.AutoExtendApprovals,
+		Observers:                   cnf.Observers,
+		Logger:                      cnf.Logger,
+		metrics:                     cnf.Metrics,
+		ctx:                         ctx,
+	}
+	i.tokens = newTokenSource(i, cnf.TokenRefreshMargin)
+
+	return i
 }
 
 // fireAPIRun starts ICANN() with a two-minute delay
@@ -72,16 +167,113 @@ func fireAPIRun(icn *IcannClient) {
 	go icn.CzdsAPI.ICANN().Run()
 }
 
-func setEnv() configData {
-	var cnf configData
+// validate checks the required values and
+// sets the defaults.
+func (cnf *Config) validate() error {
+
+	if cnf.IcannAccountPassword == "" || cnf.IcannAccountUserName == "" {
+		// stop the show; without username/password, there will be no API calls.
+		return ErrMissingCredentials
+	}
+
+	// Note that ICANN API calls will fail without a proper user-agent.
+	if cnf.UserAgent == "" {
+		return ErrMissingUserAgent
+	}
+
+	if cnf.HoursToWaitBetweenDownloads < 24 {
+		cnf.HoursToWaitBetweenDownloads = 24
+	}
+
+	if cnf.ZoneFileDir == "" {
+		return fmt.Errorf("zone-file directory is required")
+	}
+	if cnf.DiskReserve == "" {
+		cnf.DiskReserve = defaultDiskReserve
+	}
+	reserveBytes, reservePercent, err := parseDiskReserve(cnf.DiskReserve)
+	if err != nil {
+		return err
+	}
+	if cnf.ZoneStore == nil {
+		cnf.ZoneStore = &LocalZoneStore{Dir: cnf.ZoneFileDir, ReserveBytes: reserveBytes, ReservePercent: reservePercent}
+	}
+	if cnf.DiskSpaceWait == 0 {
+		cnf.DiskSpaceWait = defaultDiskSpaceWait
+	}
+
+	if cnf.TokenCache == nil {
+		if cnf.TokenKey == "" {
+			return ErrMissingTokenKey
+		}
+		cnf.TokenCache = NewFileTokenCache(filepath.Join(cnf.ZoneFileDir, tokenFileName), cnf.TokenKey)
+	}
+
+	if cnf.AuthLimiter == nil {
+		cnf.AuthLimiter = defaultAuthLimiter
+		if cnf.AuthLimitFile != "" {
+			cnf.AuthLimiter = NewAuthLimiter(cnf.AuthLimitFile)
+		}
+	}
+
+	if cnf.TokenRefreshMargin == 0 {
+		cnf.TokenRefreshMargin = defaultTokenRefreshMargin
+	}
+	if cnf.TokenRefreshMargin < 0 || cnf.TokenRefreshMargin >= maxTokenRefreshMargin {
+		return fmt.Errorf("invalid token refresh margin %s; it must be less than %s", cnf.TokenRefreshMargin, maxTokenRefreshMargin)
+	}
+
+	if cnf.Schedules, err = validateSchedules(cnf.Schedules); err != nil {
+		return err
+	}
+	if cnf.Retention, err = validateRetention(cnf.Retention); err != nil {
+		return err
+	}
+	if cnf.ApprovedTLD, err = cleanTLDPatterns(cnf.ApprovedTLD); err != nil {
+		return err
+	}
+	if cnf.ExcludedTLD, err = cleanTLDPatterns(cnf.ExcludedTLD); err != nil {
+		return err
+	}
+
+	if cnf.ApprovalWarnDays <= 0 {
+		cnf.ApprovalWarnDays = defaultApprovalWarnDays
+	}
+
+	if cnf.Logger == nil {
+		cnf.Logger = NewLogger(os.Stderr, cnf.LogFormat, cnf.LogLevel)
+	}
+
+	if cnf.HTTPClient == nil && cnf.Transport != nil {
+		cnf.HTTPClient = &http.Client{Transport: cnf.Transport}
+	}
+
+	if cnf.AccountBaseURL == "" {
+		cnf.AccountBaseURL = DefaultAccountBaseURL
+	}
+	if cnf.CzdsBaseURL == "" {
+		cnf.CzdsBaseURL = DefaultCzdsBaseURL
+	}
+	cnf.AccountBaseURL = strings.TrimSuffix(cnf.AccountBaseURL, "/")
+	cnf.CzdsBaseURL = strings.TrimSuffix(cnf.CzdsBaseURL, "/")
+
+	return nil
+}
+
+func setEnv() (Config, error) {
+	var cnf Config
 
 	installPath, err := filepath.Abs(filepath.Dir(os.Args[0]))
 	if err != nil {
-		log.Fatal(err)
+		return cnf, err
 	}
 	envFilePath := installPath + "/icann.env"
 
-	SetEnvFromFile(envFilePath)
+	if FileOrDirExists(envFilePath) {
+		if err := SetEnvFromFile(envFilePath); err != nil {
+			return cnf, err
+		}
+	}
 
 	// All required args are initialized from environment variables.
 	// So, if there is no icann.env file; then the following variables
@@ -99,31 +291,61 @@ func setEnv() configData {
 
 	cnf.HoursToWaitBetweenDownloads, _ = strconv.Atoi(os.Getenv("HOURS_TO_WAIT_BETWEEN_DOWNLOADS"))
 
-	if cnf.HoursToWaitBetweenDownloads < 24 {
-		cnf.HoursToWaitBetweenDownloads = 24
+	// e.g. 10% or 20GB; and 30m, 2h
+	cnf.DiskReserve = os.Getenv("DISK_RESERVE")
+	if v := os.Getenv("DISK_SPACE_WAIT"); v != "" {
+		if cnf.DiskSpaceWait, err = parseInterval(v); err != nil {
+			return cnf, fmt.Errorf("invalid DISK_SPACE_WAIT %q: %w", v, err)
+		}
 	}
 
-	if cnf.IcannAccountPassword == "" || cnf.IcannAccountUserName == "" {
-		// stop the show; without username/password, there will be no API calls.
-		log.Fatal("failed to get env variables")
+	// the token file is encrypted with the same key
+	// as the env file; unless set.
+	cnf.TokenKey = os.Getenv("TOKEN_KEY")
+	if cnf.TokenKey == "" {
+		cnf.TokenKey = os.Getenv("SALT_PHRASE")
+	}
+
+	cnf.AuthLimitFile = os.Getenv("AUTH_LIMIT_FILE")
+
+	if v := os.Getenv("TOKEN_REFRESH_MARGIN"); v != "" {
+		if cnf.TokenRefreshMargin, err = parseInterval(v); err != nil {
+			return cnf, fmt.Errorf("invalid TOKEN_REFRESH_MARGIN %q: %w", v, err)
+		}
+	}
+
+	// per-TLD schedules e.g. com=24h@02:00-06:00;*=7d
+	if cnf.Schedules, err = parseSchedules(os.Getenv("TLD_SCHEDULES")); err != nil {
+		return cnf, err
 	}
 
-	// Note that ICANN API calls will fail without a proper user-agent.
 	// userAgent has format of:
 	//    <name of your product> / <version> <comment about your product>
 	cnf.UserAgent = os.Getenv("USER_AGENT")
-	if cnf.UserAgent == "" {
-		// stop the show; without username/password, there will be no API calls.
-		log.Fatal("failed to get env variables")
+
+	cnf.LogLevel = parseLogLevel(os.Getenv("LOG_LEVEL"))
+	cnf.LogFormat = os.Getenv("LOG_FORMAT")
+
+	cnf.DiffAfterDownload, _ = strconv.ParseBool(os.Getenv("DIFF_AFTER_DOWNLOAD"))
+	cnf.DiffFormat = os.Getenv("DIFF_FORMAT")
+	cnf.DiffDir = os.Getenv("DIFF_DIR")
+
+	// per-TLD retention e.g. com=7d,4w,12m,500GB;*=30d
+	if cnf.Retention, err = parseRetention(os.Getenv("RETENTION")); err != nil {
+		return cnf, err
 	}
+	cnf.RetentionDryRun, _ = strconv.ParseBool(os.Getenv("RETENTION_DRY_RUN"))
+
+	cnf.WatchApprovals, _ = strconv.ParseBool(os.Getenv("WATCH_APPROVALS"))
+	cnf.ApprovalWarnDays, _ = strconv.Atoi(os.Getenv("APPROVAL_WARN_DAYS"))
+	cnf.AutoExtendApprovals, _ = strconv.ParseBool(os.Getenv("AUTO_EXTEND_APPROVALS"))
 
 	// Initialize the approvedTLD with your authrorized TLDs as the below example.
 	// Note that you must have authorization for each TLD.
-	str := strings.ToLower(os.Getenv("APPROVED_TLDS"))
-	v := strings.Split(str, ",")
-	for i := 0; i < len(v); i++ {
-		cnf.ApprovedTLD = append(cnf.ApprovedTLD, v[i])
-	}
+	// Glob patterns (e.g. co*) are supported; EXCLUDED_TLDS
+	// are not downloaded.
+	cnf.ApprovedTLD = splitEnvList(os.Getenv("APPROVED_TLDS"))
+	cnf.ExcludedTLD = splitEnvList(os.Getenv("EXCLUDED_TLDS"))
 
 	if os.Getenv("ICANN_ROOT_PATH") != "" {
 		cnf.ZoneFileDir = fmt.Sprintf("%s/appdata/zone-files", os.Getenv("ICANN_ROOT_PATH"))
@@ -133,9 +355,23 @@ func setEnv() configData {
 		cnf.ZoneFileDir = fmt.Sprintf("%s/appdata/zone-files", installPath)
 	}
 
-	if !FileOrDirExists(cnf.ZoneFileDir) {
-		os.MkdirAll(cnf.ZoneFileDir, os.ModePerm)
+	// zone files in an S3-compatible bucket; instead of ZoneFileDir
+	if os.Getenv("S3_BUCKET") != "" {
+		s3cnf := S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Region:    os.Getenv("S3_REGION"),
+		}
+		s3cnf.Secure, _ = strconv.ParseBool(os.Getenv("S3_SECURE"))
+		s3cnf.PathStyle, _ = strconv.ParseBool(os.Getenv("S3_PATH_STYLE"))
+
+		if cnf.ZoneStore, err = NewS3ZoneStore(s3cnf); err != nil {
+			return cnf, err
+		}
 	}
 
-	return cnf
+	return cnf, nil
 }
@@ -0,0 +1,131 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// NewLogger returns a logger that writes to w (stderr if nil) in
+// text or json format; it is used when Config.Logger is nil.
+func NewLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	if w == nil {
+		w = os.Stderr
+	}
+
+	opt := &slog.HandlerOptions{Level: level}
+
+	if strings.ToLower(format) == LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opt))
+	}
+
+	return slog.New(slog.NewTextHandler(w, opt))
+}
+
+// parseLogLevel parses debug, info, warn, or error;
+// info is returned for any other value.
+func parseLogLevel(s string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// logger returns the logger of the client; or
+// slog.Default() if there is none.
+func (i *IcannAPI) logger() *slog.Logger {
+	if i.Logger == nil {
+		return slog.Default()
+	}
+	return i.Logger
+}
+
+// logEvent writes an event to the logger; progress is
+// logged at debug level.
+func (i *IcannAPI) logEvent(e Event) {
+
+	level := slog.LevelInfo
+	switch e.Type {
+	case EventDownloadProgress:
+		level = slog.LevelDebug
+	case EventAuthFailed, EventApprovalMissing, EventApprovalExpiring, EventApprovalDenied:
+		level = slog.LevelWarn
+	case EventDownloadFailed:
+		level = slog.LevelWarn
+		if !e.Queued {
+			level = slog.LevelError
+		}
+	}
+
+	lg := i.logger()
+	ctx := context.Background()
+	if !lg.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, 8)
+	if e.TLD != "" {
+		attrs = append(attrs, slog.String("tld", e.TLD))
+	}
+	if e.URL != "" {
+		attrs = append(attrs, slog.String("url", e.URL))
+	}
+	if e.StatusCode != 0 {
+		attrs = append(attrs, slog.Int("status_code", e.StatusCode))
+	}
+	if e.Bytes != 0 {
+		attrs = append(attrs, slog.Uint64("bytes", e.Bytes))
+	}
+	if e.TotalBytes != 0 {
+		attrs = append(attrs, slog.Uint64("total_bytes", e.TotalBytes))
+	}
+	if e.BytesPerSecond != 0 {
+		attrs = append(attrs, slog.Int64("bytes_per_second", int64(e.BytesPerSecond)))
+	}
+	if e.ETA != 0 {
+		attrs = append(attrs, slog.Duration("eta", e.ETA))
+	}
+	if e.Elapsed != 0 {
+		attrs = append(attrs, slog.Duration("duration", e.Elapsed))
+	}
+	if e.Attempt != 0 {
+		attrs = append(attrs, slog.Int("attempt", e.Attempt), slog.Bool("queued", e.Queued))
+	}
+	if e.RequestID != "" {
+		attrs = append(attrs, slog.String("request_id", e.RequestID))
+	}
+	if e.RequestStatus != "" {
+		attrs = append(attrs, slog.String("request_status", e.RequestStatus))
+	}
+	if !e.Expires.IsZero() {
+		attrs = append(attrs, slog.Time("expires", e.Expires))
+	}
+	switch e.Type {
+	case EventLinksFetched:
+		attrs = append(attrs, slog.Int("links", e.Links))
+	case EventDownloadUnchanged:
+		attrs = append(attrs, slog.String("unchanged_since", e.UnchangedSince))
+	case EventCycleFinished:
+		attrs = append(attrs, slog.Int("links", e.Links), slog.Int("downloaded", e.Downloaded), slog.Int("failed", e.Failed))
+	case EventApprovalsChecked:
+		attrs = append(attrs, slog.Int("missing", e.Missing), slog.Int("expiring", e.Expiring), slog.Int("denied", e.Denied))
+	case EventZoneFilePruned:
+		attrs = append(attrs, slog.String("name", e.LocalFilePath), slog.Bool("dry_run", e.DryRun))
+	case EventIdle:
+		attrs = append(attrs, slog.Time("next_run", e.NextRun))
+	}
+	if e.Err != nil {
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+
+	lg.LogAttrs(ctx, level, strings.ReplaceAll(string(e.Type), "-", " "), attrs...)
+}
@@ -0,0 +1,199 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "icann"
+
+// Metrics is an Observer that keeps prometheus metrics of the
+// authentication (token renewal) and the download sessions.
+// Create it with NewMetrics, set it in Config.Metrics, and serve
+// the registry; e.g. with promhttp.Handler().
+type Metrics struct {
+	AuthAttempts       *prometheus.CounterVec
+	TokenExpiry        prometheus.Gauge
+	DownloadsStarted   *prometheus.CounterVec
+	DownloadsSucceeded *prometheus.CounterVec
+	DownloadsFailed    *prometheus.CounterVec
+	DownloadsUnchanged *prometheus.CounterVec
+	DownloadDuration   prometheus.Histogram
+	DownloadThroughput prometheus.Histogram
+	TransferBytes      *prometheus.GaugeVec
+	FailedQueueLength  prometheus.Gauge
+	LastSuccess        *prometheus.GaugeVec
+	Sessions           prometheus.Counter
+	ApprovalExpiry     *prometheus.GaugeVec
+	ApprovalIssues     *prometheus.GaugeVec
+	ZoneFilesPruned    *prometheus.CounterVec
+	PrunedBytes        prometheus.Counter
+}
+
+// NewMetrics creates the metrics and registers them with reg
+// (prometheus.DefaultRegisterer if nil).
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	m := &Metrics{
+		AuthAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "auth", Name: "attempts_total",
+			Help: "Authentication attempts by status-code (0 = no valid response).",
+		}, []string{"status_code"}),
+
+		TokenExpiry: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "auth", Name: "token_expiry_timestamp_seconds",
+			Help: "Expiry of the current access token (unix time).",
+		}),
+
+		DownloadsStarted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "downloads_started_total",
+			Help: "Zone file downloads started.",
+		}, []string{"tld"}),
+
+		DownloadsSucceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "downloads_succeeded_total",
+			Help: "Zone file downloads completed.",
+		}, []string{"tld"}),
+
+		DownloadsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "downloads_failed_total",
+			Help: "Zone file downloads failed.",
+		}, []string{"tld"}),
+
+		DownloadsUnchanged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "downloads_unchanged_total",
+			Help: "Zone files skipped as unchanged since the last download.",
+		}, []string{"tld"}),
+
+		DownloadDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "download_duration_seconds",
+			Help:    "Duration of the completed zone file downloads.",
+			Buckets: prometheus.ExponentialBuckets(1, 3, 10), // 1s .. ~5.5h
+		}),
+
+		DownloadThroughput: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "download_throughput_bytes_per_second",
+			Help:    "Throughput of the completed zone file downloads.",
+			Buckets: prometheus.ExponentialBuckets(64*1024, 2, 12), // 64 KB/s .. 128 MB/s
+		}),
+
+		TransferBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "transfer_bytes",
+			Help: "Bytes downloaded so far in the current transfer.",
+		}, []string{"tld"}),
+
+		FailedQueueLength: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "failed_download_queue_length",
+			Help: "Downloads waiting to be retried.",
+		}),
+
+		LastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "last_success_timestamp_seconds",
+			Help: "Time of the last successful download of a TLD (unix time).",
+		}, []string{"tld"}),
+
+		Sessions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "sessions_total",
+			Help: "Download sessions finished.",
+		}),
+
+		ApprovalExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "approval_expiry_timestamp_seconds",
+			Help: "Expiry of the expiring (or extended) approvals of the TLDs (unix time).",
+		}, []string{"tld"}),
+
+		ApprovalIssues: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "approval_issues",
+			Help: "TLDs that are missing, expiring, or denied; as of the last approval check.",
+		}, []string{"issue"}),
+
+		ZoneFilesPruned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "zone_files_pruned_total",
+			Help: "Zone files deleted by the retention policy.",
+		}, []string{"tld"}),
+
+		PrunedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Subsystem: "czds", Name: "pruned_bytes_total",
+			Help: "Space freed by the retention policy.",
+		}),
+	}
+
+	reg.MustRegister(m.AuthAttempts, m.TokenExpiry, m.DownloadsStarted, m.DownloadsSucceeded,
+		m.DownloadsFailed, m.DownloadsUnchanged, m.DownloadDuration, m.DownloadThroughput, m.TransferBytes,
+		m.FailedQueueLength, m.LastSuccess, m.Sessions, m.ApprovalExpiry, m.ApprovalIssues,
+		m.ZoneFilesPruned, m.PrunedBytes)
+
+	return m
+}
+
+// authAttempt counts an authentication attempt; statusCode
+// is of the response (zero for none).
+func (m *Metrics) authAttempt(statusCode int) {
+	if m != nil {
+		m.AuthAttempts.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+	}
+}
+
+// tokenChanged sets the expiry of the current access token; it is
+// called by the TokenSource, as the token may come from the cache.
+func (m *Metrics) tokenChanged(t JWT) {
+	if m != nil && !t.DateTimeExpires.IsZero() {
+		m.TokenExpiry.Set(float64(t.DateTimeExpires.Unix()))
+	}
+}
+
+// OnEvent updates the metrics from an event (the
+// authentication metrics are not kept from events).
+func (m *Metrics) OnEvent(e Event) {
+
+	switch e.Type {
+	case EventDownloadStarted:
+		m.DownloadsStarted.WithLabelValues(e.TLD).Inc()
+		m.TransferBytes.WithLabelValues(e.TLD).Set(float64(e.Bytes))
+
+	case EventDownloadProgress:
+		m.TransferBytes.WithLabelValues(e.TLD).Set(float64(e.Bytes))
+
+	case EventDownloadCompleted:
+		m.DownloadsSucceeded.WithLabelValues(e.TLD).Inc()
+		m.DownloadDuration.Observe(e.Elapsed.Seconds())
+		if e.BytesPerSecond > 0 {
+			m.DownloadThroughput.Observe(e.BytesPerSecond)
+		}
+		m.LastSuccess.WithLabelValues(e.TLD).Set(float64(e.Time.Unix()))
+		m.TransferBytes.DeleteLabelValues(e.TLD)
+
+	case EventDownloadUnchanged:
+		m.DownloadsUnchanged.WithLabelValues(e.TLD).Inc()
+		m.LastSuccess.WithLabelValues(e.TLD).Set(float64(e.Time.Unix()))
+
+	case EventDownloadFailed:
+		m.DownloadsFailed.WithLabelValues(e.TLD).Inc()
+		m.TransferBytes.DeleteLabelValues(e.TLD)
+		m.FailedQueueLength.Set(float64(e.FailedQueue))
+
+	case EventCycleFinished:
+		m.Sessions.Inc()
+		m.FailedQueueLength.Set(float64(e.FailedQueue))
+
+	case EventApprovalExpiring, EventApprovalExtended:
+		m.ApprovalExpiry.WithLabelValues(e.TLD).Set(float64(e.Expires.Unix()))
+
+	case EventApprovalsChecked:
+		m.ApprovalIssues.WithLabelValues("missing").Set(float64(e.Missing))
+		m.ApprovalIssues.WithLabelValues("expiring").Set(float64(e.Expiring))
+		m.ApprovalIssues.WithLabelValues("denied").Set(float64(e.Denied))
+
+	case EventZoneFilePruned:
+		if !e.DryRun {
+			m.ZoneFilesPruned.WithLabelValues(e.TLD).Inc()
+			m.PrunedBytes.Add(float64(e.Bytes))
+		}
+	}
+}
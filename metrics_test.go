@@ -0,0 +1,68 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kambahr/go-icann-api-client/czdstest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// saveFailsCache is a TokenCache that cannot save.
+type saveFailsCache struct {
+	TokenCache
+}
+
+var errSaveToken = errors.New("disk full")
+
+func (c saveFailsCache) Save(ctx context.Context, t JWT) error {
+	return errSaveToken
+}
+
+// The auth metrics are kept by the TokenSource; an attempt is
+// counted even if the token cannot be saved, and the expiry follows
+// a token renewed by another instance (that shares the cache).
+func TestMetricsAuth(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	cnf := testConfig(t, s)
+	m := NewMetrics(prometheus.NewRegistry())
+	cnf.Metrics = m
+
+	icn, err := New(context.Background(), cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.ToFloat64(m.AuthAttempts.WithLabelValues("200")); n != 1 {
+		t.Errorf("auth attempts (200) = %v; want 1", n)
+	}
+	if got, want := testutil.ToFloat64(m.TokenExpiry), float64(icn.CzdsAPI.ICANN().tokens.Expiry().Unix()); got != want {
+		t.Errorf("token expiry = %v; want %v", got, want)
+	}
+
+	exp := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	cnf.TokenCache.Save(context.Background(), JWT{Token: testJWT(fmt.Sprintf(`{"exp":%d}`, exp.Unix()))})
+	icn.CzdsAPI.ICANN().tokens.reload()
+	if got := testutil.ToFloat64(m.TokenExpiry); got != float64(exp.Unix()) {
+		t.Errorf("token expiry after the reload = %v; want %v", got, exp.Unix())
+	}
+
+	cnf = testConfig(t, s)
+	m = NewMetrics(prometheus.NewRegistry())
+	cnf.Metrics = m
+	cnf.TokenCache = saveFailsCache{NewMemoryTokenCache()}
+
+	if _, err := New(context.Background(), cnf); !errors.Is(err, errSaveToken) {
+		t.Fatalf("New = %v; want %v", err, errSaveToken)
+	}
+	if n := testutil.ToFloat64(m.AuthAttempts.WithLabelValues("200")); n != 1 {
+		t.Errorf("auth attempts (200) with a failed save = %v; want 1", n)
+	}
+}
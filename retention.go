@@ -0,0 +1,352 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy is how long the zone files of the TLDs that match
+// Pattern (a glob pattern; e.g. com, co*, *) are kept. A zone file is
+// kept if any of the rules keeps it; the others are deleted (with their
+// .sha256 files) at the end of each session. The latest zone file of a
+// TLD is always kept. A policy without rules keeps everything.
+type RetentionPolicy struct {
+	Pattern string
+
+	// KeepDays keeps the zone files of the last KeepDays days
+	// (of the days that have a zone file).
+	KeepDays int
+
+	// KeepWeekly and KeepMonthly keep the latest zone file of each of
+	// the last KeepWeekly weeks (ISO weeks) and KeepMonthly months.
+	KeepWeekly  int
+	KeepMonthly int
+
+	// MaxBytes keeps the latest zone files of a TLD while their
+	// total size is under MaxBytes. Hardlinks (and symlinks) of the
+	// same file are counted once.
+	MaxBytes int64
+}
+
+// PrunedZoneFile is a zone file deleted by the retention
+// policy (or that would be deleted; in a dry-run).
+type PrunedZoneFile struct {
+	TLD  string
+	Date string
+	Name string
+
+	// Bytes is the space that is freed; zero for a link
+	// of a file that is kept.
+	Bytes int64
+}
+
+// retainedSnapshot is a zone file of a TLD in the ZoneStore.
+type retainedSnapshot struct {
+	ZoneObjectInfo
+	date  string
+	t     time.Time
+	group int // the index of the first snapshot of the same file
+}
+
+// PruneZoneFiles deletes the zone files that are not kept by the
+// retention policies (Config.Retention); with dryRun, nothing is
+// deleted. The files are returned and sent to the observers
+// (EventZoneFilePruned). The TLDs without a policy are not pruned.
+func (c *CzdsAPI) PruneZoneFiles(dryRun bool) ([]PrunedZoneFile, error) {
+
+	if len(c.icann.Retention) == 0 {
+		return nil, nil
+	}
+
+	ctx := c.icann.context()
+	store := c.icann.ZoneStore
+
+	files, err := store.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	snapshots := make(map[string][]retainedSnapshot)
+	for _, fi := range files {
+		names[fi.Name] = true
+		date, tld, ok := parseZoneFileName(fi.Name)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			continue
+		}
+		snapshots[tld] = append(snapshots[tld], retainedSnapshot{ZoneObjectInfo: fi, date: date, t: t})
+	}
+
+	var tlds []string
+	for tld := range snapshots {
+		tlds = append(tlds, tld)
+	}
+	sort.Strings(tlds)
+
+	var pruned []PrunedZoneFile
+
+	for _, tld := range tlds {
+		p, ok := c.icann.retentionFor(tld)
+		if !ok {
+			continue
+		}
+
+		// the latest first
+		list := snapshots[tld]
+		sort.Slice(list, func(i, j int) bool { return list[i].date > list[j].date })
+
+		groupSnapshots(store, list)
+
+		keep := retainSnapshots(p, list)
+
+		// the space of a file is freed when all its links are deleted
+		keptGroups := make(map[int]bool)
+		for i := range list {
+			if keep[i] {
+				keptGroups[list[i].group] = true
+			}
+		}
+		freed := make(map[int]bool)
+
+		for i := len(list) - 1; i >= 0; i-- {
+			if keep[i] {
+				continue
+			}
+			pf := PrunedZoneFile{TLD: tld, Date: list[i].date, Name: list[i].Name}
+			if g := list[i].group; !keptGroups[g] && !freed[g] {
+				pf.Bytes = list[i].Size
+				freed[g] = true
+			}
+
+			if !dryRun {
+				if err := store.Delete(ctx, pf.Name); err != nil {
+					return pruned, err
+				}
+				if names[pf.Name+sha256FileExt] {
+					if err := store.Delete(ctx, pf.Name+sha256FileExt); err != nil {
+						return pruned, err
+					}
+				}
+			}
+
+			pruned = append(pruned, pf)
+			c.icann.emit(Event{Type: EventZoneFilePruned, TLD: tld, LocalFilePath: pf.Name,
+				Bytes: uint64(pf.Bytes), DryRun: dryRun})
+		}
+	}
+
+	// .sha256 files whose zone file is gone
+	if !dryRun {
+		for name := range names {
+			zf, ok := strings.CutSuffix(name, sha256FileExt)
+			if !ok || names[zf] {
+				continue
+			}
+			if _, tld, ok := parseZoneFileName(zf); ok {
+				if _, ok := c.icann.retentionFor(tld); ok {
+					store.Delete(ctx, name)
+				}
+			}
+		}
+	}
+
+	return pruned, nil
+}
+
+// retentionFor returns the retention policy of a TLD; the first
+// matching policy applies. False if there is none (or it has no rules).
+func (i *IcannAPI) retentionFor(tld string) (RetentionPolicy, bool) {
+	for _, p := range i.Retention {
+		if matchTLDPattern([]string{p.Pattern}, tld) {
+			return p, p.KeepDays > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0 || p.MaxBytes > 0
+		}
+	}
+	return RetentionPolicy{}, false
+}
+
+// retainSnapshots returns the snapshots (the latest first)
+// kept by a policy.
+func retainSnapshots(p RetentionPolicy, list []retainedSnapshot) []bool {
+
+	keep := make([]bool, len(list))
+	if len(list) == 0 {
+		return keep
+	}
+
+	keep[0] = true
+
+	for i := 0; i < len(list) && i < p.KeepDays; i++ {
+		keep[i] = true
+	}
+
+	weeks := make(map[string]bool)
+	months := make(map[string]bool)
+	for i := range list {
+		y, w := list[i].t.ISOWeek()
+		if wk := fmt.Sprintf("%d-%d", y, w); !weeks[wk] && len(weeks) < p.KeepWeekly {
+			weeks[wk] = true
+			keep[i] = true
+		}
+		if mo := list[i].date[:7]; !months[mo] && len(months) < p.KeepMonthly {
+			months[mo] = true
+			keep[i] = true
+		}
+	}
+
+	if p.MaxBytes > 0 {
+		var total int64
+		counted := make(map[int]bool)
+		for i := range list {
+			size := list[i].Size
+			if counted[list[i].group] {
+				size = 0
+			}
+			if total+size > p.MaxBytes {
+				break
+			}
+			total += size
+			counted[list[i].group] = true
+			keep[i] = true
+		}
+	}
+
+	return keep
+}
+
+// groupSnapshots sets the group of the snapshots that are links of
+// the same file (local stores only); the objects of other stores
+// are copies.
+func groupSnapshots(store ZoneStore, list []retainedSnapshot) {
+
+	ls, isLocal := store.(*LocalZoneStore)
+
+	var infos []os.FileInfo
+	if isLocal {
+		infos = make([]os.FileInfo, len(list))
+		for i := range list {
+			infos[i], _ = os.Stat(ls.path(list[i].Name))
+		}
+	}
+
+	for i := range list {
+		list[i].group = i
+		if !isLocal || infos[i] == nil {
+			continue
+		}
+		for j := 0; j < i; j++ {
+			if infos[j] != nil && os.SameFile(infos[i], infos[j]) {
+				list[i].group = list[j].group
+				break
+			}
+		}
+	}
+}
+
+// validateRetention cleans the patterns of the retention policies.
+func validateRetention(policies []RetentionPolicy) ([]RetentionPolicy, error) {
+
+	list := make([]RetentionPolicy, 0, len(policies))
+
+	for _, v := range policies {
+		p, err := cleanTLDPatterns([]string{v.Pattern})
+		if err != nil {
+			return nil, err
+		}
+		if len(p) == 0 {
+			return nil, fmt.Errorf("retention policy without a TLD pattern")
+		}
+		v.Pattern = p[0]
+
+		if v.KeepDays < 0 || v.KeepWeekly < 0 || v.KeepMonthly < 0 || v.MaxBytes < 0 {
+			return nil, fmt.Errorf("invalid retention policy of %s", v.Pattern)
+		}
+
+		list = append(list, v)
+	}
+
+	return list, nil
+}
+
+// parseRetention parses the RETENTION env variable; the entries are
+// separated by semicolon in format of pattern=rule[,rule...] where a
+// rule is <n>d (days), <n>w (weeks), <n>m (months), or a size e.g.
+//
+//	com=7d,4w,12m,500GB;*=30d
+func parseRetention(s string) ([]RetentionPolicy, error) {
+
+	var list []RetentionPolicy
+
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, rules, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention %q (pattern=rule[,rule...])", entry)
+		}
+
+		p := RetentionPolicy{Pattern: strings.TrimSpace(pattern)}
+
+		for _, rule := range strings.Split(rules, ",") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+
+			var err error
+			switch {
+			case strings.HasSuffix(rule, "d"):
+				p.KeepDays, err = strconv.Atoi(strings.TrimSuffix(rule, "d"))
+			case strings.HasSuffix(rule, "w"):
+				p.KeepWeekly, err = strconv.Atoi(strings.TrimSuffix(rule, "w"))
+			case strings.HasSuffix(rule, "m"):
+				p.KeepMonthly, err = strconv.Atoi(strings.TrimSuffix(rule, "m"))
+			default:
+				p.MaxBytes, err = parseByteSize(rule)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid retention %q: %q", entry, rule)
+			}
+		}
+
+		list = append(list, p)
+	}
+
+	return list, nil
+}
+
+// parseByteSize parses a size e.g. 500GB, 2TB, 750MB
+// (powers of 1024); a number without a unit is in bytes.
+func parseByteSize(s string) (int64, error) {
+
+	units := []struct {
+		suffix string
+		n      int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	}
+
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range units {
+		if v, ok := strings.CutSuffix(s, u.suffix); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || f < 0 {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return int64(f * float64(u.n)), nil
+		}
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
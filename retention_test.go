@@ -0,0 +1,159 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kambahr/go-icann-api-client/czdstest"
+)
+
+func TestParseRetention(t *testing.T) {
+
+	list, err := parseRetention("com=7d,4w,12m,1.5GB; *=30d ;net=500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[{com 7 4 12 1610612736} {* 30 0 0 0} {net 0 0 0 500}]"
+	if got := fmt.Sprint(list); got != want {
+		t.Errorf("policies = %s; want %s", got, want)
+	}
+
+	for _, s := range []string{"com", "com=xd", "com=10XB", "com=-1GB"} {
+		if _, err := parseRetention(s); err == nil {
+			t.Errorf("parseRetention(%q) did not fail", s)
+		}
+	}
+	if _, err := validateRetention([]RetentionPolicy{{Pattern: "com", KeepDays: -1}}); err == nil {
+		t.Error("validateRetention with a negative rule did not fail")
+	}
+}
+
+// The latest file of each week (ISO) and month is kept; and
+// always the latest file.
+func TestRetainSnapshots(t *testing.T) {
+
+	var list []retainedSnapshot
+	for d := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC); d.Month() >= 8; d = d.AddDate(0, 0, -1) {
+		list = append(list, retainedSnapshot{ZoneObjectInfo: ZoneObjectInfo{Size: 10}, date: d.Format("2006-01-02"), t: d, group: len(list)})
+	}
+
+	kept := func(p RetentionPolicy) string {
+		var dates []string
+		for i, ok := range retainSnapshots(p, list) {
+			if ok {
+				dates = append(dates, list[i].date)
+			}
+		}
+		return fmt.Sprint(dates)
+	}
+
+	if got := kept(RetentionPolicy{KeepWeekly: 2, KeepMonthly: 3}); got != "[2026-10-17 2026-10-11 2026-09-30 2026-08-31]" {
+		t.Errorf("weekly/monthly: kept %s", got)
+	}
+	if got := kept(RetentionPolicy{KeepDays: 2}); got != "[2026-10-17 2026-10-16]" {
+		t.Errorf("days: kept %s", got)
+	}
+	if got := kept(RetentionPolicy{MaxBytes: 1}); got != "[2026-10-17]" {
+		t.Errorf("the latest is not kept: %s", got)
+	}
+}
+
+// writeRetentionFiles writes the zone files of com to dir; each
+// date is either a new file (of size bytes) or a link of the date
+// before it (an unchanged zone file).
+func writeRetentionFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	files := []struct {
+		day  int
+		size int // 0: a link of the day before
+	}{
+		{10, 100}, {11, 0}, {12, 200}, {13, 0}, {14, 0}, {15, 300}, {16, 400}, {17, 0},
+	}
+
+	for _, f := range files {
+		fp := filepath.Join(dir, fmt.Sprintf("2026-10-%d-com.zone.gz", f.day))
+		if f.size == 0 {
+			prev := filepath.Join(dir, fmt.Sprintf("2026-10-%d-com.zone.gz", f.day-1))
+			if err := os.Link(prev, fp); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.WriteFile(fp, make([]byte, f.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(fp+sha256FileExt, []byte("digest"), 0644)
+	}
+}
+
+// prunedString returns date:bytes of the pruned files.
+func prunedString(pruned []PrunedZoneFile) string {
+	var v []string
+	for _, pf := range pruned {
+		v = append(v, fmt.Sprintf("%s:%d", pf.Date[8:], pf.Bytes))
+	}
+	return strings.Join(v, " ")
+}
+
+// The links of a file (unchanged zone files) free its space once
+// all of them are deleted; and the size limit counts them once.
+func TestPruneZoneFilesLinks(t *testing.T) {
+
+	for _, tc := range []struct {
+		name   string
+		policy RetentionPolicy
+		pruned string
+	}{
+		// 13 and 12 are links of 14; 11 of 10
+		{"days", RetentionPolicy{Pattern: "com", KeepDays: 4}, "10:100 11:0 12:0 13:0"},
+		// 17,16: 400; 15: 300; 14,13,12: 200
+		{"size", RetentionPolicy{Pattern: "co*", MaxBytes: 900}, "10:100 11:0"},
+		{"size under", RetentionPolicy{Pattern: "com", MaxBytes: 899}, "10:100 11:0 12:200 13:0 14:0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := czdstest.NewServer()
+			defer s.Close()
+
+			cnf := testConfig(t, s)
+			cnf.Retention = []RetentionPolicy{tc.policy, {Pattern: "*", KeepDays: 1}}
+			c, events := newTestClient(t, cnf)
+
+			dir := c.icann.AppDataDir
+			writeRetentionFiles(t, dir)
+
+			// a dry-run deletes nothing
+			pruned, err := c.PruneZoneFiles(true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := prunedString(pruned); got != tc.pruned {
+				t.Errorf("dry-run pruned %s; want %s", got, tc.pruned)
+			}
+			if files, _ := filepath.Glob(filepath.Join(dir, "*-com.zone.gz")); len(files) != 8 {
+				t.Error("the dry-run deleted files")
+			}
+
+			pruned, err = c.PruneZoneFiles(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := prunedString(pruned); got != tc.pruned {
+				t.Errorf("pruned %s; want %s", got, tc.pruned)
+			}
+			for _, pf := range pruned {
+				for _, name := range []string{pf.Name, pf.Name + sha256FileExt} {
+					if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+						t.Errorf("%s is not deleted", name)
+					}
+				}
+			}
+			if n := len(events.types("com")); n != 2*len(pruned) {
+				t.Errorf("%d events; want %d (EventZoneFilePruned of each)", n, 2*len(pruned))
+			}
+		})
+	}
+}
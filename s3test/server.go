@@ -0,0 +1,478 @@
+// (c) Kamiar Bahri
+
+// Package s3test runs an in-process fake of an S3-compatible object
+// store (a small subset of the S3 api, as MinIO would respond), so that
+// S3ZoneStore can be exercised without a MinIO server. Requests are
+// path-style (S3Config.PathStyle) and the signatures are not checked.
+//
+// The following requests are simulated:
+//
+//	GET    /<bucket>?location                  (bucket location)
+//	HEAD   /<bucket>
+//	GET    /<bucket>?list-type=2               (ListObjectsV2; prefix and delimiter)
+//	PUT    /<bucket>/<key>                     (aws-chunked bodies are decoded)
+//	PUT    /<bucket>/<key> x-amz-copy-source   (CopyObject)
+//	POST   /<bucket>/<key>?uploads             (multipart upload)
+//	PUT    /<bucket>/<key>?partNumber&uploadId (UploadPart, UploadPartCopy)
+//	POST   /<bucket>/<key>?uploadId            (complete)
+//	DELETE /<bucket>/<key>?uploadId            (abort)
+//	GET    /<bucket>/<key>, HEAD /<bucket>/<key> (Range is supported)
+//	DELETE /<bucket>/<key>
+//
+// Faults can be injected; see FailUploadsAfter.
+package s3test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake S3-compatible object store.
+type Server struct {
+	*httptest.Server
+
+	// Endpoint is the host:port of the server (S3Config.Endpoint).
+	Endpoint string
+
+	mu        sync.Mutex
+	buckets   map[string]map[string]*object
+	uploads   map[string]*upload
+	lastID    int
+	failParts int // parts to accept before UploadPart fails; -1 for none
+}
+
+// object is an object in a bucket.
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// upload is a multipart upload in progress.
+type upload struct {
+	bucket string
+	key    string
+	parts  map[int][]byte
+}
+
+// NewServer starts a new fake server with the buckets. Callers must
+// call Close when done.
+func NewServer(buckets ...string) *Server {
+	s := &Server{
+		buckets:   make(map[string]map[string]*object),
+		uploads:   make(map[string]*upload),
+		failParts: -1,
+	}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string]*object)
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.Endpoint = strings.TrimPrefix(s.URL, "http://")
+
+	return s
+}
+
+// PutObject adds (or replaces) an object.
+func (s *Server) PutObject(bucket string, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.putObject(bucket, key, data)
+}
+
+// Object returns the content of an object; false if it does not exist.
+func (s *Server) Object(bucket string, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	return o.data, true
+}
+
+// Objects returns the keys of the objects in a bucket; sorted.
+func (s *Server) Objects(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// IncompleteUploads returns the number of multipart uploads that
+// were neither completed nor aborted.
+func (s *Server) IncompleteUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.uploads)
+}
+
+// FailUploadsAfter makes UploadPart respond with 403 (AccessDenied; not
+// retried) after n more parts have been accepted; -1 removes the fault.
+func (s *Server) FailUploadsAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failParts = n
+}
+
+func (s *Server) putObject(bucket string, key string, data []byte) *object {
+	h := md5.Sum(data)
+	o := &object{data: data, etag: `"` + hex.EncodeToString(h[:]) + `"`, modTime: time.Now().UTC().Truncate(time.Second)}
+	s.buckets[bucket][key] = o
+	return o
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	if key == "" {
+		switch {
+		case r.Method == http.MethodGet && q.Has("location"):
+			writeXML(w, http.StatusOK, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+				Value   string   `xml:",chardata"`
+			}{})
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet:
+			s.listObjects(w, objects, q)
+		default:
+			writeError(w, r, http.StatusNotImplemented, "NotImplemented")
+		}
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.lastID++
+		id := strconv.Itoa(s.lastID)
+		s.uploads[id] = &upload{bucket: bucket, key: key, parts: make(map[int][]byte)}
+		writeXML(w, http.StatusOK, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		s.uploadPart(w, r, q)
+
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		s.completeUpload(w, r, q.Get("uploadId"))
+
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		src, err := s.copySource(r)
+		if err != nil {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		o := s.putObject(bucket, key, src.data)
+		writeXML(w, http.StatusOK, struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			ETag         string
+			LastModified string
+		}{ETag: o.etag, LastModified: o.modTime.Format(time.RFC3339)})
+
+	case r.Method == http.MethodPut:
+		data, err := readBody(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		o := s.putObject(bucket, key, data)
+		w.Header().Set("ETag", o.etag)
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		o, ok := objects[key]
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", o.etag)
+		http.ServeContent(w, r, "", o.modTime, bytes.NewReader(o.data))
+
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// listObjects responds to ListObjectsV2 (without paging).
+func (s *Server) listObjects(w http.ResponseWriter, objects map[string]*object, q url.Values) {
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	res := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		KeyCount       int
+		MaxKeys        int
+		Delimiter      string `xml:",omitempty"`
+		IsTruncated    bool
+		Contents       []content
+		CommonPrefixes []commonPrefix
+	}{Prefix: q.Get("prefix"), MaxKeys: 1000, Delimiter: q.Get("delimiter")}
+
+	var keys []string
+	for k := range objects {
+		if strings.HasPrefix(k, res.Prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if res.Delimiter != "" {
+			if i := strings.Index(k[len(res.Prefix):], res.Delimiter); i >= 0 {
+				p := k[:len(res.Prefix)+i+len(res.Delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{p})
+				}
+				continue
+			}
+		}
+		o := objects[k]
+		res.Contents = append(res.Contents, content{Key: k, LastModified: o.modTime.Format(time.RFC3339),
+			ETag: o.etag, Size: len(o.data), StorageClass: "STANDARD"})
+	}
+	res.KeyCount = len(res.Contents) + len(res.CommonPrefixes)
+
+	writeXML(w, http.StatusOK, res)
+}
+
+// uploadPart responds to UploadPart and UploadPartCopy.
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, q url.Values) {
+
+	u, ok := s.uploads[q.Get("uploadId")]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	n, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || n < 1 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+
+	if s.failParts == 0 {
+		// (a 5xx would be retried by the client)
+		writeError(w, r, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if s.failParts > 0 {
+		s.failParts--
+	}
+
+	var data []byte
+	if r.Header.Get("x-amz-copy-source") != "" {
+		src, err := s.copySource(r)
+		if err != nil {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		data = src.data
+		if rng := r.Header.Get("x-amz-copy-source-range"); rng != "" {
+			var start, end int
+			if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil || start > end || end >= len(data) {
+				writeError(w, r, http.StatusBadRequest, "InvalidRange")
+				return
+			}
+			data = data[start : end+1]
+		}
+	} else if data, err = readBody(r); err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	u.parts[n] = data
+
+	h := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(h[:]) + `"`
+
+	if r.Header.Get("x-amz-copy-source") != "" {
+		writeXML(w, http.StatusOK, struct {
+			XMLName      xml.Name `xml:"CopyPartResult"`
+			ETag         string
+			LastModified string
+		}{ETag: etag, LastModified: time.Now().UTC().Format(time.RFC3339)})
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeUpload joins the parts listed in the request.
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, id string) {
+
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	var req struct {
+		Parts []struct {
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var data []byte
+	for _, p := range req.Parts {
+		b, ok := u.parts[p.PartNumber]
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data = append(data, b...)
+	}
+
+	delete(s.uploads, id)
+	o := s.putObject(u.bucket, u.key, data)
+
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: u.bucket, Key: u.key, ETag: o.etag})
+}
+
+// copySource returns the object in the x-amz-copy-source header.
+func (s *Server) copySource(r *http.Request) (*object, error) {
+
+	src, err := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		return nil, err
+	}
+	src, _, _ = strings.Cut(src, "?")
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+
+	o, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, errors.New("no such key")
+	}
+
+	return o, nil
+}
+
+// readBody reads the body of a request; the aws-chunked bodies
+// (streaming signatures) are decoded.
+func readBody(r *http.Request) ([]byte, error) {
+
+	if !strings.HasPrefix(r.Header.Get("x-amz-content-sha256"), "STREAMING-") &&
+		!strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data []byte
+	br := bufio.NewReader(r.Body)
+
+	for {
+		// <hex-size>;chunk-signature=<sig>\r\n<data>\r\n
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sz, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(sz, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+		if _, err := br.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+
+	if dl := r.Header.Get("x-amz-decoded-content-length"); dl != "" && dl != strconv.Itoa(len(data)) {
+		return nil, fmt.Errorf("%d bytes decoded; expected %s", len(data), dl)
+	}
+
+	return data, nil
+}
+
+func writeXML(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, statusCode int, code string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(statusCode)
+		return
+	}
+	writeXML(w, statusCode, struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}{Code: code, Message: code, Resource: r.URL.Path})
+}
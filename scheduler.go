@@ -0,0 +1,333 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// scheduleFileName is the file (in AppDataDir) that keeps the
+	// last download times of the TLDs.
+	scheduleFileName = "schedule.json"
+
+	// minDownloadInterval is ICANN's limit; a zone file may be
+	// downloaded once in 24 hours.
+	minDownloadInterval = 24 * time.Hour
+
+	// scheduleRetryDelay is the wait after the first failed download
+	// of a TLD; it doubles with each failure (up to the interval).
+	scheduleRetryDelay = time.Hour
+
+	// minSchedulerSleep is the least time the scheduler sleeps
+	// between two sessions.
+	minSchedulerSleep = time.Minute
+)
+
+// TLDSchedule is the download schedule of the TLDs that match
+// Pattern (a glob pattern; e.g. com, co*, *).
+type TLDSchedule struct {
+	Pattern string
+
+	// Interval is the time between two downloads of a TLD; at least
+	// 24 hours. Default is Config.HoursToWaitBetweenDownloads.
+	Interval time.Duration
+
+	// Window limits the downloads to a time of the day (UTC); either
+	// a range (e.g. 02:00-06:00) or a cron expression of five fields
+	// (minute hour day-of-month month day-of-week) e.g. "* 2-5 * * 1-5".
+	// Blank for any time.
+	Window string
+}
+
+// scheduler decides when each TLD is downloaded; the last download
+// times are kept in scheduleFileName so that they survive restarts.
+type scheduler struct {
+	mu sync.Mutex
+
+	filePath        string
+	defaultInterval time.Duration
+	schedules       []compiledSchedule
+	state           map[string]*tldScheduleState
+}
+
+type compiledSchedule struct {
+	TLDSchedule
+	window timeWindow
+}
+
+// tldScheduleState is the download history of a TLD.
+type tldScheduleState struct {
+	// LastSuccess is the start time of the last successful download.
+	LastSuccess time.Time `json:"lastSuccess"`
+
+	// LastAttempt and Failures are of the failed downloads
+	// since the last success.
+	LastAttempt time.Time `json:"lastAttempt,omitempty"`
+	Failures    int       `json:"failures,omitempty"`
+}
+
+// newScheduler loads the state of the scheduler from dir;
+// schedules must have been validated.
+func newScheduler(dir string, defaultInterval time.Duration, schedules []TLDSchedule) (*scheduler, error) {
+
+	s := &scheduler{
+		filePath:        filepath.Join(dir, scheduleFileName),
+		defaultInterval: defaultInterval,
+		state:           make(map[string]*tldScheduleState),
+	}
+
+	for _, v := range schedules {
+		w, err := parseTimeWindow(v.Window)
+		if err != nil {
+			return nil, err
+		}
+		s.schedules = append(s.schedules, compiledSchedule{TLDSchedule: v, window: w})
+	}
+
+	b, err := os.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.state); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.filePath, err)
+	}
+
+	return s, nil
+}
+
+// scheduleFor returns the interval and the window of a TLD;
+// the first matching schedule applies.
+func (s *scheduler) scheduleFor(tld string) (time.Duration, timeWindow) {
+	for _, v := range s.schedules {
+		if matchTLDPattern([]string{v.Pattern}, tld) {
+			interval := v.Interval
+			if interval == 0 {
+				interval = s.defaultInterval
+			}
+			return interval, v.window
+		}
+	}
+	return s.defaultInterval, nil
+}
+
+// nextDue returns the time a TLD can be downloaded next; a
+// time before now (or now) means the TLD is due.
+func (s *scheduler) nextDue(tld string, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	interval, window := s.scheduleFor(tld)
+	if interval < minDownloadInterval {
+		interval = minDownloadInterval
+	}
+
+	t := now
+
+	if st := s.state[tld]; st != nil {
+		if !st.LastSuccess.IsZero() {
+			t = st.LastSuccess.Add(interval)
+		}
+		if st.Failures > 0 {
+			if r := st.LastAttempt.Add(retryDelay(st.Failures, interval)); r.After(t) {
+				t = r
+			}
+		}
+	}
+
+	if t.Before(now) {
+		t = now
+	}
+
+	if window != nil {
+		t = window.nextOpen(t)
+	}
+
+	return t
+}
+
+// due returns true if a TLD can be downloaded now.
+func (s *scheduler) due(tld string, now time.Time) bool {
+	return !s.nextDue(tld, now).After(now)
+}
+
+// nextRun returns the earliest time that any of the TLDs is due; but
+// not sooner than minSchedulerSleep. The default interval is used if
+// there are no TLDs.
+func (s *scheduler) nextRun(tlds []string, now time.Time) time.Time {
+
+	next := now.Add(s.defaultInterval)
+
+	for _, tld := range tlds {
+		if t := s.nextDue(tld, now); t.Before(next) {
+			next = t
+		}
+	}
+
+	if min := now.Add(minSchedulerSleep); next.Before(min) {
+		next = min
+	}
+
+	return next
+}
+
+// recordSuccess records a successful download that started at started.
+func (s *scheduler) recordSuccess(tld string, started time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state[tld] = &tldScheduleState{LastSuccess: started}
+
+	return s.save()
+}
+
+// recordFailure records a failed download.
+func (s *scheduler) recordFailure(tld string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.state[tld]
+	if st == nil {
+		st = &tldScheduleState{}
+		s.state[tld] = st
+	}
+	st.LastAttempt = at
+	st.Failures++
+
+	return s.save()
+}
+
+// recordExisting records a zone file found on disk (i.e. downloaded
+// before the state was kept) as a success at modTime; unless a later
+// success is known.
+func (s *scheduler) recordExisting(tld string, modTime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st := s.state[tld]; st != nil && !st.LastSuccess.Before(modTime) {
+		return nil
+	}
+	s.state[tld] = &tldScheduleState{LastSuccess: modTime}
+
+	return s.save()
+}
+
+// save writes the state to a temp file and renames it; so
+// that a crash does not leave a partial file. s.mu must be locked.
+func (s *scheduler) save() error {
+
+	b, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, s.filePath)
+}
+
+// retryDelay is scheduleRetryDelay doubled for each failure;
+// up to max.
+func retryDelay(failures int, max time.Duration) time.Duration {
+	d := scheduleRetryDelay
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// parseSchedules parses the TLD_SCHEDULES env variable; the entries are
+// separated by semicolon in format of pattern=interval[@window] e.g.
+//
+//	com=24h@02:00-06:00;net=48h;*=7d
+func parseSchedules(s string) ([]TLDSchedule, error) {
+
+	var list []TLDSchedule
+
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, rest, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule %q (pattern=interval[@window])", entry)
+		}
+
+		var sch TLDSchedule
+		sch.Pattern = strings.TrimSpace(pattern)
+
+		interval, window, _ := strings.Cut(rest, "@")
+		sch.Window = strings.TrimSpace(window)
+
+		if interval = strings.TrimSpace(interval); interval != "" {
+			d, err := parseInterval(interval)
+			if err != nil {
+				return nil, fmt.Errorf("invalid schedule %q: %w", entry, err)
+			}
+			sch.Interval = d
+		}
+
+		list = append(list, sch)
+	}
+
+	return list, nil
+}
+
+// parseInterval is time.ParseDuration that also accepts
+// days (e.g. 7d).
+func parseInterval(s string) (time.Duration, error) {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// validateSchedules cleans the patterns, checks the windows, and
+// raises the intervals to ICANN's minimum.
+func validateSchedules(schedules []TLDSchedule) ([]TLDSchedule, error) {
+
+	list := make([]TLDSchedule, 0, len(schedules))
+
+	for _, v := range schedules {
+		p, err := cleanTLDPatterns([]string{v.Pattern})
+		if err != nil {
+			return nil, err
+		}
+		if len(p) == 0 {
+			return nil, fmt.Errorf("schedule without a TLD pattern")
+		}
+		v.Pattern = p[0]
+
+		if v.Interval != 0 && v.Interval < minDownloadInterval {
+			v.Interval = minDownloadInterval
+		}
+		if _, err := parseTimeWindow(v.Window); err != nil {
+			return nil, err
+		}
+
+		list = append(list, v)
+	}
+
+	return list, nil
+}
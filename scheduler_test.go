@@ -0,0 +1,232 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// utc returns a time of 2026-10-<day> (a Saturday is the 17th).
+func utc(day int, hour int, min int) time.Time {
+	return time.Date(2026, 10, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestParseTimeWindow(t *testing.T) {
+
+	for _, tc := range []struct {
+		window string
+		at     time.Time
+		in     bool
+		next   time.Time
+	}{
+		{"02:00-06:00", utc(17, 3, 0), true, utc(17, 3, 0)},
+		{"02:00-06:00", utc(17, 6, 0), false, utc(18, 2, 0)},
+		{"02:00-06:00", utc(17, 1, 59), false, utc(17, 2, 0)},
+		// past midnight
+		{"22:00-04:00", utc(17, 23, 30), true, utc(17, 23, 30)},
+		{"22:00-04:00", utc(17, 3, 59), true, utc(17, 3, 59)},
+		{"22:00-04:00", utc(17, 12, 0), false, utc(17, 22, 0)},
+		// minutes 0-29 of hours 2-5; weekdays
+		{"0-29 2-5 * * 1-5", utc(19, 2, 15), true, utc(19, 2, 15)},
+		{"0-29 2-5 * * 1-5", utc(19, 2, 30), false, utc(19, 3, 0)},
+		{"0-29 2-5 * * 1-5", utc(17, 2, 15), false, utc(19, 2, 0)},
+		{"*/15 * * * *", utc(17, 10, 7), false, utc(17, 10, 15)},
+		// the 1st, or a Sunday (either one when both are set)
+		{"0 0 1 * 7", utc(18, 0, 0), true, utc(18, 0, 0)},
+		{"0 0 1 * 7", utc(19, 0, 0), false, utc(25, 0, 0)},
+		{"0 0 1 * 0", utc(26, 0, 0), false, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		w, err := parseTimeWindow(tc.window)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.window, err)
+		}
+		if got := w.contains(tc.at); got != tc.in {
+			t.Errorf("%q contains %v = %v; want %v", tc.window, tc.at, got, tc.in)
+		}
+		if got := w.nextOpen(tc.at); !got.Equal(tc.next) {
+			t.Errorf("%q nextOpen %v = %v; want %v", tc.window, tc.at, got, tc.next)
+		}
+	}
+
+	if w, err := parseTimeWindow(" "); w != nil || err != nil {
+		t.Errorf("a blank window = %v, %v; want nil", w, err)
+	}
+	for _, s := range []string{"02:00-02:00", "25:00-06:00", "02:00-06:60", "* * *", "60 * * * *",
+		"* 5-2 * * *", "*/0 * * * *", "x * * * *"} {
+		if _, err := parseTimeWindow(s); err == nil {
+			t.Errorf("parseTimeWindow(%q) did not fail", s)
+		}
+	}
+}
+
+func TestParseSchedules(t *testing.T) {
+
+	list, err := parseSchedules("com=24h@02:00-06:00; net=48h ;*=7d;org=@0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[{com 24h0m0s 02:00-06:00} {net 48h0m0s } {* 168h0m0s } {org 0s 0 2 * * *}]"
+	if got := fmt.Sprint(list); got != want {
+		t.Errorf("schedules = %s; want %s", got, want)
+	}
+
+	for _, s := range []string{"com", "com=1x", "com=xd"} {
+		if _, err := parseSchedules(s); err == nil {
+			t.Errorf("parseSchedules(%q) did not fail", s)
+		}
+	}
+
+	// raised to ICANN's 24 hours
+	list, err = validateSchedules([]TLDSchedule{{Pattern: " COM ", Interval: time.Hour}})
+	if err != nil || list[0].Pattern != "com" || list[0].Interval != minDownloadInterval {
+		t.Errorf("validateSchedules = %v, %v", list, err)
+	}
+	if _, err := validateSchedules([]TLDSchedule{{Pattern: "com", Window: "02:00"}}); err == nil {
+		t.Error("validateSchedules with a bad window did not fail")
+	}
+}
+
+// The first matching schedule sets the interval of a TLD; the
+// default interval applies to the rest.
+func TestSchedulerInterval(t *testing.T) {
+
+	s, err := newScheduler(t.TempDir(), 24*time.Hour, []TLDSchedule{
+		{Pattern: "com", Interval: 48 * time.Hour},
+		{Pattern: "co*", Interval: 72 * time.Hour},
+		{Pattern: "net"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := utc(17, 12, 0)
+	for tld, want := range map[string]time.Duration{"com": 48 * time.Hour, "coop": 72 * time.Hour,
+		"net": 24 * time.Hour, "org": 24 * time.Hour} {
+
+		if !s.due(tld, now) {
+			t.Errorf("%s is not due before its first download", tld)
+		}
+		if err := s.recordSuccess(tld, now); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.nextDue(tld, now); !got.Equal(now.Add(want)) {
+			t.Errorf("%s: next due %v; want %v", tld, got, now.Add(want))
+		}
+	}
+}
+
+// Each failure doubles the wait before the next attempt; up to
+// the interval. A success clears the failures.
+func TestSchedulerRetryBackoff(t *testing.T) {
+
+	for failures, want := range map[int]time.Duration{1: time.Hour, 2: 2 * time.Hour, 3: 4 * time.Hour,
+		5: 16 * time.Hour, 6: 24 * time.Hour, 10: 24 * time.Hour} {
+		if got := retryDelay(failures, 24*time.Hour); got != want {
+			t.Errorf("retryDelay(%d) = %v; want %v", failures, got, want)
+		}
+	}
+
+	s, err := newScheduler(t.TempDir(), 24*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := utc(17, 12, 0)
+	s.recordFailure("com", now)
+	s.recordFailure("com", now)
+	if got := s.nextDue("com", now); !got.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("after two failures: next due %v; want %v", got, now.Add(2*time.Hour))
+	}
+	if s.due("com", now.Add(time.Hour)) || !s.due("com", now.Add(2*time.Hour)) {
+		t.Error("due does not follow the backoff")
+	}
+
+	s.recordSuccess("com", now)
+	if got := s.nextDue("com", now); !got.Equal(now.Add(24 * time.Hour)) {
+		t.Errorf("after a success: next due %v; want %v", got, now.Add(24*time.Hour))
+	}
+}
+
+// A TLD that is due outside its window waits for the window to
+// open; nextRun is the earliest of the TLDs.
+func TestSchedulerWindow(t *testing.T) {
+
+	s, err := newScheduler(t.TempDir(), 24*time.Hour, []TLDSchedule{
+		{Pattern: "com", Window: "02:00-06:00"},
+		{Pattern: "net", Window: "22:00-01:00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// downloaded at 05:30; due again at 05:30 (in the window)
+	s.recordSuccess("com", utc(16, 5, 30))
+	if !s.due("com", utc(17, 5, 30)) {
+		t.Error("com is not due in its window")
+	}
+
+	// downloaded at 05:50 on the 17th; due at 05:50 on the 18th (in
+	// the window); once the window has closed, on the 19th at 02:00.
+	s.recordSuccess("com", utc(17, 5, 50))
+	now := utc(18, 1, 0)
+	if got := s.nextDue("com", now); !got.Equal(utc(18, 5, 50)) {
+		t.Errorf("com: next due %v; want %v", got, utc(18, 5, 50))
+	}
+	now = utc(18, 7, 0)
+	if got := s.nextDue("com", now); !got.Equal(utc(19, 2, 0)) {
+		t.Errorf("com after the window: next due %v; want %v", got, utc(19, 2, 0))
+	}
+
+	// net opens at 22:00 (and passes midnight); org any time
+	s.recordSuccess("net", utc(17, 0, 30))
+	s.recordSuccess("org", utc(18, 20, 0))
+	if got := s.nextRun([]string{"com", "net", "org"}, now); !got.Equal(utc(18, 22, 0)) {
+		t.Errorf("nextRun = %v; want %v", got, utc(18, 22, 0))
+	}
+	if got := s.nextRun([]string{"com", "net", "org"}, utc(18, 23, 0)); !got.Equal(utc(18, 23, 1)) {
+		t.Errorf("nextRun with net due = %v; want %v (minSchedulerSleep)", got, utc(18, 23, 1))
+	}
+	if got := s.nextRun(nil, now); !got.Equal(now.Add(24 * time.Hour)) {
+		t.Errorf("nextRun without TLDs = %v; want %v", got, now.Add(24*time.Hour))
+	}
+}
+
+// The download times are kept in schedule.json; a new scheduler
+// (i.e. after a restart) picks them up.
+func TestSchedulerReload(t *testing.T) {
+
+	dir := t.TempDir()
+	s, err := newScheduler(dir, 24*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.recordSuccess("com", utc(17, 2, 0))
+	s.recordFailure("net", utc(17, 3, 0))
+	// an older file does not replace a known success
+	s.recordExisting("com", utc(16, 2, 0))
+	s.recordExisting("org", utc(16, 4, 0))
+
+	s2, err := newScheduler(dir, 24*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := utc(17, 12, 0)
+	for tld, want := range map[string]time.Time{"com": utc(18, 2, 0), "net": utc(17, 12, 0), "org": utc(17, 12, 0)} {
+		if got := s2.nextDue(tld, now); !got.Equal(want) {
+			t.Errorf("%s: next due %v; want %v", tld, got, want)
+		}
+	}
+	if st := s2.state["net"]; st == nil || st.Failures != 1 || !st.LastAttempt.Equal(utc(17, 3, 0)) {
+		t.Errorf("net = %+v", st)
+	}
+
+	// a damaged file is an error
+	os.WriteFile(filepath.Join(dir, scheduleFileName), []byte("{"), 0644)
+	if _, err := newScheduler(dir, 24*time.Hour, nil); err == nil {
+		t.Error("newScheduler with a damaged schedule.json did not fail")
+	}
+}
@@ -0,0 +1,246 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// downloadStateFileName is the journal (in AppDataDir) of the
+	// downloads; one json record per line.
+	downloadStateFileName = "download-state.jsonl"
+
+	// maxDownloadAttempts is the number of times a zone file is
+	// tried in a day; the first attempt and two retries.
+	maxDownloadAttempts = 3
+)
+
+// DownloadStatus is the status of a DownloadRecord.
+type DownloadStatus string
+
+const (
+	DownloadInProgress DownloadStatus = "in-progress"
+	DownloadCompleted  DownloadStatus = "completed"
+	DownloadFailed     DownloadStatus = "failed"
+
+	// DownloadDenied is a download that CZDS denied (403; see
+	// ZoneAccessError); it is not retried.
+	DownloadDenied DownloadStatus = "denied"
+)
+
+// DownloadRecord is the state of the download of a TLD on a day
+// (the date in the name of the zone file).
+type DownloadRecord struct {
+	TLD           string         `json:"tld"`
+	Date          string         `json:"date"` // YYYY-MM-DD
+	URL           string         `json:"url"`
+	LocalFilePath string         `json:"localFilePath"`
+	Status        DownloadStatus `json:"status"`
+
+	// ContentLength is the size reported by the HEAD request;
+	// BytesReceived is the size of the file (or the partial file).
+	ContentLength int64 `json:"contentLength"`
+	BytesReceived int64 `json:"bytesReceived"`
+
+	// SHA256 is the hex digest of the completed file.
+	SHA256 string `json:"sha256,omitempty"`
+
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+
+	// UnchangedSince is the date of the zone file that this one is
+	// linked to; the remote file had not changed since then.
+	UnchangedSince string `json:"unchangedSince,omitempty"`
+
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	LastError  string    `json:"lastError,omitempty"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished,omitempty"`
+}
+
+// stateStore keeps the DownloadRecords in a journal file; each change
+// appends the whole record, and the latest line of a TLD+date wins.
+// The journal is compacted when it is opened.
+type stateStore struct {
+	mu       sync.Mutex
+	filePath string
+	records  map[string]*DownloadRecord
+}
+
+func stateKey(tld string, date string) string {
+	return tld + "/" + date
+}
+
+// openStateStore reads the journal in dir; a torn line at the
+// end (i.e. a crash while writing) is ignored.
+func openStateStore(dir string) (*stateStore, error) {
+
+	s := &stateStore{
+		filePath: filepath.Join(dir, downloadStateFileName),
+		records:  make(map[string]*DownloadRecord),
+	}
+
+	f, err := os.Open(s.filePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		lines++
+		var rec DownloadRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil || rec.TLD == "" {
+			continue
+		}
+		s.records[stateKey(rec.TLD, rec.Date)] = &rec
+	}
+	err = sc.Err()
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// (a torn line must not be followed by the next record)
+	if lines > len(s.records) {
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// get returns the record of a TLD on a day.
+func (s *stateStore) get(tld string, date string) (DownloadRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[stateKey(tld, date)]
+	if !ok {
+		return DownloadRecord{}, false
+	}
+	return *rec, true
+}
+
+// put saves a record; it is appended to the journal and synced.
+func (s *stateStore) put(rec DownloadRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.filePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	s.records[stateKey(rec.TLD, rec.Date)] = &rec
+
+	return nil
+}
+
+// list returns the records of a day (all days if date is
+// blank); sorted by TLD and date.
+func (s *stateStore) list(date string) []DownloadRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []DownloadRecord
+	for _, rec := range s.records {
+		if date == "" || rec.Date == date {
+			list = append(list, *rec)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].TLD != list[j].TLD {
+			return list[i].TLD < list[j].TLD
+		}
+		return list[i].Date < list[j].Date
+	})
+
+	return list
+}
+
+// latest returns the latest completed record of a TLD
+// before date.
+func (s *stateStore) latest(tld string, before string) (DownloadRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last *DownloadRecord
+	for _, rec := range s.records {
+		if rec.TLD == tld && rec.Date < before && rec.Status == DownloadCompleted &&
+			(last == nil || rec.Date > last.Date) {
+			last = rec
+		}
+	}
+	if last == nil {
+		return DownloadRecord{}, false
+	}
+	return *last, true
+}
+
+// retryable returns the failed records of a day that have
+// not reached maxDownloadAttempts.
+func (s *stateStore) retryable(date string) []DownloadRecord {
+	var list []DownloadRecord
+	for _, rec := range s.list(date) {
+		if rec.Status == DownloadFailed && rec.Attempts < maxDownloadAttempts {
+			list = append(list, rec)
+		}
+	}
+	return list
+}
+
+// compact rewrites the journal with one line per record.
+func (s *stateStore) compact() error {
+
+	tmpPath := s.filePath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, rec := range s.records {
+		b, _ := json.Marshal(rec)
+		w.Write(append(b, '\n'))
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, s.filePath)
+}
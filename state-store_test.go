@@ -0,0 +1,147 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The records (the latest line of each) are read back by a
+// new store; i.e. after a restart.
+func TestStateStoreReload(t *testing.T) {
+
+	dir := t.TempDir()
+	s, err := openStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rec := range []DownloadRecord{
+		{TLD: "com", Date: "2026-10-16", Status: DownloadCompleted, SHA256: "aa"},
+		{TLD: "com", Date: "2026-10-17", Status: DownloadInProgress, Attempts: 1},
+		{TLD: "net", Date: "2026-10-17", Status: DownloadFailed, Attempts: 1, StatusCode: 503},
+		{TLD: "com", Date: "2026-10-17", Status: DownloadCompleted, Attempts: 1, BytesReceived: 100},
+	} {
+		if err := s.put(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s2, err := openStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(recordKeys(s2.list(""))); got != "[com/2026-10-16 completed com/2026-10-17 completed net/2026-10-17 failed]" {
+		t.Errorf("records = %s", got)
+	}
+	if rec, ok := s2.get("com", "2026-10-17"); !ok || rec.BytesReceived != 100 {
+		t.Errorf("get = %+v, %v", rec, ok)
+	}
+	if rec, ok := s2.latest("com", "2026-10-17"); !ok || rec.Date != "2026-10-16" {
+		t.Errorf("latest = %+v, %v; want the record of 2026-10-16", rec, ok)
+	}
+	if _, ok := s2.latest("net", "2026-10-18"); ok {
+		t.Error("latest returned a failed download")
+	}
+
+	// the overwritten lines are dropped on open
+	if n := journalLines(t, dir); n != 3 {
+		t.Errorf("journal lines = %d; want 3 (compacted)", n)
+	}
+}
+
+// A torn last line (i.e. a crash while writing) is dropped; the
+// journal is compacted so that the next record is not appended
+// to the torn line.
+func TestStateStoreTornLine(t *testing.T) {
+
+	dir := t.TempDir()
+	s, err := openStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.put(DownloadRecord{TLD: "com", Date: "2026-10-17", Status: DownloadCompleted})
+	s.put(DownloadRecord{TLD: "net", Date: "2026-10-17", Status: DownloadFailed, Attempts: 1})
+
+	f, err := os.OpenFile(filepath.Join(dir, downloadStateFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"tld":"org","date":"2026-10-17","stat`)
+	f.Close()
+
+	s2, err := openStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s2.list("")); n != 2 {
+		t.Errorf("records = %d; want 2", n)
+	}
+	if n := journalLines(t, dir); n != 2 {
+		t.Errorf("journal lines = %d; want 2 (compacted)", n)
+	}
+
+	if err := s2.put(DownloadRecord{TLD: "org", Date: "2026-10-17", Status: DownloadCompleted}); err != nil {
+		t.Fatal(err)
+	}
+	s3, err := openStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, ok := s3.get("org", "2026-10-17"); !ok || rec.Status != DownloadCompleted {
+		t.Errorf("the record after the torn line = %+v, %v", rec, ok)
+	}
+	if _, err := os.Stat(filepath.Join(dir, downloadStateFileName+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("the temp file of the compaction is left behind: %v", err)
+	}
+}
+
+// Only the failed downloads of the day that have attempts left
+// are retried; a denied download is not.
+func TestStateStoreRetryable(t *testing.T) {
+
+	s, err := openStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rec := range []DownloadRecord{
+		{TLD: "a", Date: "2026-10-17", Status: DownloadFailed, Attempts: 1},
+		{TLD: "b", Date: "2026-10-17", Status: DownloadFailed, Attempts: maxDownloadAttempts - 1},
+		{TLD: "c", Date: "2026-10-17", Status: DownloadFailed, Attempts: maxDownloadAttempts},
+		{TLD: "d", Date: "2026-10-17", Status: DownloadDenied, Attempts: 1, StatusCode: 403},
+		{TLD: "e", Date: "2026-10-17", Status: DownloadCompleted, Attempts: 1},
+		{TLD: "f", Date: "2026-10-17", Status: DownloadInProgress, Attempts: 1},
+		{TLD: "g", Date: "2026-10-16", Status: DownloadFailed, Attempts: 1},
+	} {
+		if err := s.put(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := fmt.Sprint(recordKeys(s.retryable("2026-10-17"))); got != "[a/2026-10-17 failed b/2026-10-17 failed]" {
+		t.Errorf("retryable = %s", got)
+	}
+}
+
+// recordKeys returns tld/date status of each record.
+func recordKeys(list []DownloadRecord) []string {
+	var keys []string
+	for _, rec := range list {
+		keys = append(keys, stateKey(rec.TLD, rec.Date)+" "+string(rec.Status))
+	}
+	return keys
+}
+
+func journalLines(t *testing.T, dir string) int {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join(dir, downloadStateFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(b), "\n")
+}
@@ -0,0 +1,220 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeWindow is a recurring period of time (in UTC) that
+// downloads are allowed in.
+type timeWindow interface {
+	contains(t time.Time) bool
+
+	// nextOpen returns t if t is in the window; otherwise
+	// the time the window opens next.
+	nextOpen(t time.Time) time.Time
+}
+
+// parseTimeWindow parses a range (02:00-06:00) or a cron
+// expression; nil is returned for a blank window.
+func parseTimeWindow(s string) (timeWindow, error) {
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	if from, to, ok := strings.Cut(s, "-"); ok && strings.Contains(from, ":") {
+		f, err1 := parseClock(from)
+		t, err2 := parseClock(to)
+		if err1 != nil || err2 != nil || f == t {
+			return nil, fmt.Errorf("invalid time window %q (e.g. 02:00-06:00)", s)
+		}
+		return rangeWindow{from: f, to: t}, nil
+	}
+
+	return parseCronWindow(s)
+}
+
+// parseClock parses hh:mm into minutes of the day.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil || hh < 0 || hh > 24 || mm < 0 || mm > 59 || (hh == 24 && mm != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hh*60 + mm, nil
+}
+
+// rangeWindow is a daily range; from and to are minutes of the
+// day. If from > to the range passes midnight (e.g. 22:00-04:00).
+type rangeWindow struct {
+	from int
+	to   int
+}
+
+func (w rangeWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	m := t.Hour()*60 + t.Minute()
+
+	if w.from < w.to {
+		return m >= w.from && m < w.to
+	}
+	return m >= w.from || m < w.to
+}
+
+func (w rangeWindow) nextOpen(t time.Time) time.Time {
+	if w.contains(t) {
+		return t
+	}
+
+	u := t.UTC()
+	open := time.Date(u.Year(), u.Month(), u.Day(), 0, w.from, 0, 0, time.UTC)
+	if open.Before(u) {
+		open = open.AddDate(0, 0, 1)
+	}
+
+	return open
+}
+
+// cronWindow is a cron expression; the window is open during
+// each minute that matches the expression.
+type cronWindow struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny are true for *; as in cron, if both
+	// days are restricted, either one matches.
+	domAny, dowAny bool
+}
+
+func parseCronWindow(s string) (timeWindow, error) {
+
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid time window %q (a range e.g. 02:00-06:00, or a cron expression)", s)
+	}
+
+	var w cronWindow
+	var err error
+
+	if w.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("time window %q: minute: %w", s, err)
+	}
+	if w.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("time window %q: hour: %w", s, err)
+	}
+	if w.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("time window %q: day of month: %w", s, err)
+	}
+	if w.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("time window %q: month: %w", s, err)
+	}
+	if w.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("time window %q: day of week: %w", s, err)
+	}
+
+	// 7 is also Sunday
+	if w.dow&(1<<7) != 0 {
+		w.dow |= 1
+	}
+
+	w.domAny = fields[2] == "*"
+	w.dowAny = fields[4] == "*"
+
+	return w, nil
+}
+
+// parseCronField parses a field of a cron expression (*, */n, a,
+// a-b, a-b/n, and lists of them) into a bit set.
+func parseCronField(s string, min int, max int) (uint64, error) {
+
+	var bits uint64
+
+	for _, part := range strings.Split(s, ",") {
+
+		step := 1
+		if r, st, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(st)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			part, step = r, n
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			a, b, isRange := strings.Cut(part, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range %q (%d-%d)", part, min, max)
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+func (w cronWindow) dayMatches(t time.Time) bool {
+	dom := w.dom&(1<<uint(t.Day())) != 0
+	dow := w.dow&(1<<uint(t.Weekday())) != 0
+
+	if w.domAny || w.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (w cronWindow) contains(t time.Time) bool {
+	t = t.UTC()
+
+	return w.minute&(1<<uint(t.Minute())) != 0 &&
+		w.hour&(1<<uint(t.Hour())) != 0 &&
+		w.month&(1<<uint(t.Month())) != 0 &&
+		w.dayMatches(t)
+}
+
+func (w cronWindow) nextOpen(t time.Time) time.Time {
+	if w.contains(t) {
+		return t
+	}
+
+	u := t.UTC().Truncate(time.Minute).Add(time.Minute)
+
+	// jump a day (or an hour) at a time where possible; give
+	// up after a few years (e.g. Feb 30).
+	limit := u.AddDate(5, 0, 0)
+	for u.Before(limit) {
+		switch {
+		case w.month&(1<<uint(u.Month())) == 0 || !w.dayMatches(u):
+			u = time.Date(u.Year(), u.Month(), u.Day()+1, 0, 0, 0, 0, time.UTC)
+		case w.hour&(1<<uint(u.Hour())) == 0:
+			u = u.Truncate(time.Hour).Add(time.Hour)
+		case w.minute&(1<<uint(u.Minute())) == 0:
+			u = u.Add(time.Minute)
+		default:
+			return u
+		}
+	}
+
+	return limit
+}
@@ -0,0 +1,96 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// cleanTLDPatterns lower-cases the TLD patterns and removes the
+// blanks and the leading dots (i.e. .com => com); an error is
+// returned for an invalid glob pattern.
+func cleanTLDPatterns(patterns []string) ([]string, error) {
+
+	var list []string
+
+	for _, p := range patterns {
+		p = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(p)), ".")
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid TLD pattern %q: %w", p, err)
+		}
+		list = append(list, p)
+	}
+
+	return list, nil
+}
+
+// matchTLDPattern returns true if tld matches any of the
+// patterns; i.e. com, co*, x?z, *.
+func matchTLDPattern(patterns []string, tld string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, tld); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isGlobPattern returns true if p is not a plain TLD name.
+func isGlobPattern(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// tldSelected returns true if tld is to be downloaded; i.e. it
+// matches ApprovedTLD (all TLDs if blank) and not ExcludedTLD.
+func (i *IcannAPI) tldSelected(tld string) bool {
+
+	tld = strings.ToLower(tld)
+
+	if len(i.ApprovedTLD) > 0 && !matchTLDPattern(i.ApprovedTLD, tld) {
+		return false
+	}
+
+	return !matchTLDPattern(i.ExcludedTLD, tld)
+}
+
+// filterDownloadLinks returns the links of the selected TLDs, and the
+// ApprovedTLD entries that match none of the links; i.e. listed but
+// not approved (or no longer approved).
+func (c *CzdsAPI) filterDownloadLinks(dlinks []string) ([]string, []string) {
+
+	var selected []string
+	var missing []string
+
+	linkTLDs := make(map[string]bool)
+
+	for _, link := range dlinks {
+		tld := getTLDFromDownloadLink(link)
+		linkTLDs[tld] = true
+
+		if c.icann.tldSelected(tld) {
+			selected = append(selected, link)
+		}
+	}
+
+	for _, p := range c.icann.ApprovedTLD {
+		if p == "*" {
+			continue
+		}
+		found := false
+		for tld := range linkTLDs {
+			if ok, _ := path.Match(p, tld); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, p)
+		}
+	}
+
+	return selected, missing
+}
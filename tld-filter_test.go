@@ -0,0 +1,79 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCleanTLDPatterns(t *testing.T) {
+
+	list, err := cleanTLDPatterns([]string{" .COM", "", "co*", "  ", "x?z", "[a-c]*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(list) != "[com co* x?z [a-c]*]" {
+		t.Errorf("patterns = %q", list)
+	}
+
+	if _, err := cleanTLDPatterns([]string{"com", "[a-"}); err == nil {
+		t.Error("an invalid pattern did not fail")
+	}
+}
+
+func TestMatchTLDPattern(t *testing.T) {
+
+	for _, tc := range []struct {
+		patterns []string
+		tld      string
+		want     bool
+	}{
+		{[]string{"com"}, "com", true},
+		{[]string{"com"}, "coop", false},
+		{[]string{"net", "co*"}, "coop", true},
+		{[]string{"x?z"}, "xyz", true},
+		{[]string{"x?z"}, "xz", false},
+		{[]string{"*"}, "xn--p1ai", true},
+		{nil, "com", false},
+	} {
+		if got := matchTLDPattern(tc.patterns, tc.tld); got != tc.want {
+			t.Errorf("matchTLDPattern(%q, %s) = %v; want %v", tc.patterns, tc.tld, got, tc.want)
+		}
+	}
+}
+
+// The links are filtered by ApprovedTLD and ExcludedTLD; the entries
+// of ApprovedTLD that match none of the links are reported.
+func TestFilterDownloadLinks(t *testing.T) {
+
+	var links []string
+	for _, tld := range []string{"com", "coop", "net", "org", "xyz"} {
+		links = append(links, "https://czds-download-api.icann.org/czds/downloads/"+tld+".zone")
+	}
+
+	for _, tc := range []struct {
+		approved []string
+		excluded []string
+		selected string
+		missing  string
+	}{
+		{nil, nil, "[com coop net org xyz]", "[]"},
+		{[]string{"*"}, []string{"co*"}, "[net org xyz]", "[]"},
+		{[]string{"co*", "net", "info"}, nil, "[com coop net]", "[info]"},
+		{[]string{"com", "b*"}, []string{"com"}, "[]", "[b*]"},
+		{nil, []string{"org", "x?z"}, "[com coop net]", "[]"},
+	} {
+		c := &CzdsAPI{icann: &IcannAPI{ApprovedTLD: tc.approved, ExcludedTLD: tc.excluded}}
+
+		selected, missing := c.filterDownloadLinks(links)
+
+		var tlds []string
+		for _, link := range selected {
+			tlds = append(tlds, getTLDFromDownloadLink(link))
+		}
+		if fmt.Sprint(tlds) != tc.selected || fmt.Sprint(missing) != tc.missing {
+			t.Errorf("approved %q, excluded %q: selected %v, missing %v; want %s, %s",
+				tc.approved, tc.excluded, tlds, missing, tc.selected, tc.missing)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// TokenCache keeps the access token between runs. Instances that
+// share one (e.g. behind one NAT) share the token; and so the
+// per-IP limit of authentication attempts. Implement it to keep
+// the token elsewhere (e.g. in a database or redis).
+type TokenCache interface {
+	// Load returns the cached token; a zero JWT
+	// (and nil error) if there is none.
+	Load(ctx context.Context) (JWT, error)
+
+	// Save replaces the cached token with t.
+	Save(ctx context.Context, t JWT) error
+}
+
+// FileTokenCache keeps the token in a file; encrypted with Key (see
+// EncryptLight) and readable only by the owner (0600). Processes on
+// the same host (or a shared volume) can use the same file. A blank
+// Key is refused (ErrMissingTokenKey).
+type FileTokenCache struct {
+	Path string
+	Key  string
+}
+
+// NewFileTokenCache returns a cache in path; key is the
+// passphrase of the encryption.
+func NewFileTokenCache(path string, key string) *FileTokenCache {
+	return &FileTokenCache{Path: path, Key: key}
+}
+
+func (c *FileTokenCache) Load(ctx context.Context) (JWT, error) {
+
+	var t JWT
+
+	if c.Key == "" {
+		return t, ErrMissingTokenKey
+	}
+
+	b, err := os.ReadFile(c.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+
+	b, err = hex.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return t, err
+	}
+
+	if bytes.HasPrefix(b, []byte("{")) {
+		// written by an older version (not encrypted); it is
+		// saved again encrypted.
+		if err := json.Unmarshal(b, &t); err != nil {
+			return t, err
+		}
+		return t, c.Save(ctx, t)
+	}
+
+	b, err = DecryptLight(b, c.Key)
+	if err != nil {
+		return t, err
+	}
+
+	err = json.Unmarshal(b, &t)
+
+	return t, err
+}
+
+// Save writes the token to a temp file (0600), and renames it
+// to Path; so that a reader never sees a partial file.
+func (c *FileTokenCache) Save(ctx context.Context, t JWT) error {
+
+	if c.Key == "" {
+		return ErrMissingTokenKey
+	}
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	b, err = EncryptLight(b, c.Key)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.Path, []byte(hex.EncodeToString(b)))
+}
+
+// MemoryTokenCache keeps the token in memory; e.g. for tests, or
+// to share one token between several clients in a process.
+type MemoryTokenCache struct {
+	mu    sync.Mutex
+	token JWT
+}
+
+// NewMemoryTokenCache returns an empty in-memory cache.
+func NewMemoryTokenCache() *MemoryTokenCache {
+	return &MemoryTokenCache{}
+}
+
+func (c *MemoryTokenCache) Load(ctx context.Context) (JWT, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.token, nil
+}
+
+func (c *MemoryTokenCache) Save(ctx context.Context, t JWT) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token = t
+
+	return nil
+}
@@ -0,0 +1,68 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileTokenCache(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), tokenFileName)
+	tok := JWT{Token: "secret-token", DateTimeExpires: time.Now().Add(time.Hour).Round(0)}
+
+	c := NewFileTokenCache(path, "pepper")
+	if err := c.Save(ctx, tok); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret-token") {
+		t.Error("the token is not encrypted in the token file")
+	}
+
+	got, err := c.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Token != tok.Token || !got.DateTimeExpires.Equal(tok.DateTimeExpires) {
+		t.Errorf("Load = %+v; want %+v", got, tok)
+	}
+
+	if _, err := NewFileTokenCache(path, "salt").Load(ctx); err == nil {
+		t.Error("Load with another key did not fail")
+	}
+}
+
+// A blank key would leave the token file as good as plain.
+func TestFileTokenCacheBlankKey(t *testing.T) {
+
+	ctx := context.Background()
+	c := NewFileTokenCache(filepath.Join(t.TempDir(), tokenFileName), "")
+
+	if err := c.Save(ctx, JWT{Token: "x"}); !errors.Is(err, ErrMissingTokenKey) {
+		t.Errorf("Save = %v; want ErrMissingTokenKey", err)
+	}
+	if _, err := c.Load(ctx); !errors.Is(err, ErrMissingTokenKey) {
+		t.Errorf("Load = %v; want ErrMissingTokenKey", err)
+	}
+
+	cnf := Config{UserAgent: "x", IcannAccountUserName: "u", IcannAccountPassword: "p", ZoneFileDir: t.TempDir()}
+	if err := cnf.validate(); !errors.Is(err, ErrMissingTokenKey) {
+		t.Errorf("validate = %v; want ErrMissingTokenKey", err)
+	}
+
+	cnf.TokenCache = NewMemoryTokenCache()
+	if err := cnf.validate(); err != nil {
+		t.Errorf("validate with a TokenCache = %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTokenRefreshMargin is how long before its expiry
+	// (exp) the access token is renewed; unless set.
+	defaultTokenRefreshMargin = time.Hour
+
+	// maxTokenRefreshMargin keeps the margin well within the
+	// lifetime (24 hours) of the ICANN tokens.
+	maxTokenRefreshMargin = 12 * time.Hour
+
+	// tokenLifetime is assumed for a token without claims.
+	tokenLifetime = 24 * time.Hour
+)
+
+// errNotJWT is returned by jwtClaims for a token
+// that cannot be read as a JWT.
+var errNotJWT = errors.New("access token is not a JWT")
+
+// TokenSource gives the access token (JWT) of the ICANN account; it
+// is safe for concurrent use. IcannAPI and CzdsAPI share one; callers
+// can get it from IcannAPI.TokenSource() to make their own api calls.
+type TokenSource interface {
+	// Token returns a valid access token; it authenticates first if
+	// there is no token, or it expires within the refresh margin.
+	Token(ctx context.Context) (JWT, error)
+
+	// Refresh authenticates and returns the new access token.
+	Refresh(ctx context.Context) (JWT, error)
+
+	// Expiry returns the expiry of the current access
+	// token; zero if there is none.
+	Expiry() time.Time
+}
+
+// icannTokenSource is the TokenSource of an IcannAPI. Concurrent
+// callers that need a new token share one authentication.
+type icannTokenSource struct {
+	icann  *IcannAPI
+	margin time.Duration // renew this long before exp
+
+	mu     sync.Mutex
+	token  JWT
+	loaded bool // the TokenCache has been read
+	flight *tokenFlight
+	err    error // of the last authentication
+}
+
+// tokenFlight is an authentication in progress; done
+// is closed when token and err are set.
+type tokenFlight struct {
+	done  chan struct{}
+	token JWT
+	err   error
+}
+
+func newTokenSource(i *IcannAPI, margin time.Duration) *icannTokenSource {
+	return &icannTokenSource{icann: i, margin: margin}
+}
+
+func (ts *icannTokenSource) Token(ctx context.Context) (JWT, error) {
+
+	now := time.Now()
+
+	t := ts.current()
+	if !t.valid(now.Add(ts.margin)) {
+		// another instance (that shares the TokenCache)
+		// may have renewed the token.
+		t = ts.reload()
+	}
+	if t.valid(now.Add(ts.margin)) {
+		return t, nil
+	}
+
+	nt, err := ts.Refresh(ctx)
+	if err != nil && ctx.Err() == nil && t.valid(now) {
+		// the current token is still good until it expires;
+		// the renewal is tried again on the next call.
+		return t, nil
+	}
+
+	return nt, err
+}
+
+// renew gets a new token after the server rejected t; unless t
+// has already been replaced (e.g. by a concurrent caller).
+func (ts *icannTokenSource) renew(ctx context.Context, t JWT) (JWT, error) {
+
+	cur := ts.current()
+	if cur.Token == t.Token {
+		cur = ts.reload()
+	}
+	if cur.Token != t.Token && cur.valid(time.Now()) {
+		return cur, nil
+	}
+
+	return ts.Refresh(ctx)
+}
+
+// current returns the current token (valid or not); the
+// TokenCache is read on the first call.
+func (ts *icannTokenSource) current() JWT {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.loaded {
+		ts.setToken(ts.icann.loadAccessToken().withClaims())
+		ts.loaded = true
+	}
+
+	return ts.token
+}
+
+// reload reads the TokenCache; the cached token (the last one
+// saved) replaces the current one; unless it expires earlier.
+func (ts *icannTokenSource) reload() JWT {
+
+	t := ts.icann.loadAccessToken().withClaims()
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if t.Token != "" && !t.DateTimeExpires.Before(ts.token.DateTimeExpires) {
+		ts.setToken(t)
+	}
+	ts.loaded = true
+
+	return ts.token
+}
+
+// Refresh authenticates; if an authentication is already in
+// progress, its result is returned instead. The authentication
+// (and its wait for the rate-limit) stops when ctx is done.
+func (ts *icannTokenSource) Refresh(ctx context.Context) (JWT, error) {
+
+lblAgain:
+
+	ts.mu.Lock()
+	f := ts.flight
+	if f != nil {
+		ts.mu.Unlock()
+		select {
+		case <-f.done:
+			if isContextError(f.err) && ctx.Err() == nil {
+				// the caller that made the attempt gave up;
+				// this one has not.
+				goto lblAgain
+			}
+			return f.token, f.err
+		case <-ctx.Done():
+			return JWT{}, ctx.Err()
+		}
+	}
+
+	f = &tokenFlight{done: make(chan struct{})}
+	ts.flight = f
+	ts.mu.Unlock()
+
+	f.token, f.err = ts.icann.authenticate(ctx)
+
+	ts.mu.Lock()
+	ts.err = f.err
+	if f.err == nil {
+		ts.setToken(f.token)
+		ts.loaded = true
+	}
+	ts.flight = nil
+	ts.mu.Unlock()
+
+	close(f.done)
+
+	return f.token, f.err
+}
+
+// setToken replaces the current token; ts.mu must be locked.
+func (ts *icannTokenSource) setToken(t JWT) {
+	ts.token = t
+	ts.icann.metrics.tokenChanged(t)
+}
+
+func (ts *icannTokenSource) Expiry() time.Time {
+	return ts.current().DateTimeExpires
+}
+
+// lastError returns the error of the last authentication;
+// nil if it succeeded (or none has been made).
+func (ts *icannTokenSource) lastError() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.err
+}
+
+// refreshAt returns when the current token is due for renewal.
+func (ts *icannTokenSource) refreshAt() time.Time {
+	return ts.Expiry().Add(-ts.margin)
+}
+
+// valid returns true if t is a token that has not expired.
+func (t JWT) valid(now time.Time) bool {
+	return t.Token != "" && now.Before(t.DateTimeExpires)
+}
+
+// withClaims returns t with the issued and expiry times read
+// from the token (iat and exp); t is returned as is if the
+// token has no such claims.
+func (t JWT) withClaims() JWT {
+
+	if t.Token == "" {
+		return t
+	}
+
+	iat, exp, err := jwtClaims(t.Token)
+	if err != nil {
+		return t
+	}
+
+	t.DateTimeExpires = exp
+	if !iat.IsZero() {
+		t.DateTimeIssued = iat
+	}
+
+	return t
+}
+
+// jwtClaims reads the iat and exp claims of a JWT. The signature is
+// not verified; the token is only ever sent back to ICANN.
+func jwtClaims(token string) (time.Time, time.Time, error) {
+
+	var iat, exp time.Time
+
+	v := strings.Split(token, ".")
+	if len(v) != 3 {
+		return iat, exp, errNotJWT
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(v[1], "="))
+	if err != nil {
+		return iat, exp, errNotJWT
+	}
+
+	var claims struct {
+		Iat float64 `json:"iat"`
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil || claims.Exp <= 0 {
+		return iat, exp, errNotJWT
+	}
+
+	exp = time.Unix(int64(claims.Exp), 0)
+	if claims.Iat > 0 {
+		iat = time.Unix(int64(claims.Iat), 0)
+	}
+
+	return iat, exp, nil
+}
+
+// isContextError returns true if err is of a context
+// that was cancelled or has passed its deadline.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
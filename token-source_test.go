@@ -0,0 +1,114 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJWT returns a JWT (not signed) with the claims.
+func testJWT(claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		enc.EncodeToString([]byte(claims)) + ".c2lnbmF0dXJl"
+}
+
+func TestJWTClaims(t *testing.T) {
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		iat   int64
+		exp   int64
+		ok    bool
+	}{
+		{"iat and exp", testJWT(`{"sub":"u","iat":1760700000,"exp":1760786400}`), 1760700000, 1760786400, true},
+		{"no iat", testJWT(`{"exp":1760786400}`), 0, 1760786400, true},
+		{"padded", strings.Replace(testJWT(`{"exp":1760786400}`), ".c2ln", "=.c2ln", 1), 0, 1760786400, true},
+		{"no exp", testJWT(`{"iat":1760700000}`), 0, 0, false},
+		{"not json", testJWT(`exp=1760786400`), 0, 0, false},
+		{"two parts", "eyJhbGciOiJIUzI1NiJ9.eyJleHAiOjF9", 0, 0, false},
+		{"bad base64", "a.!!!.c", 0, 0, false},
+		{"opaque", "0123456789abcdef", 0, 0, false},
+	} {
+		iat, exp, err := jwtClaims(tc.token)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v", tc.name, err)
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+		if exp.Unix() != tc.exp || (tc.iat == 0) != iat.IsZero() || (tc.iat != 0 && iat.Unix() != tc.iat) {
+			t.Errorf("%s: iat %v, exp %v; want %d, %d", tc.name, iat, exp, tc.iat, tc.exp)
+		}
+	}
+}
+
+// The times of a token are those of its claims; a token that
+// cannot be read keeps the times it has.
+func TestJWTWithClaims(t *testing.T) {
+
+	now := time.Now()
+
+	expired := JWT{Token: testJWT(`{"iat":1760700000,"exp":1760786400}`), DateTimeExpires: now.Add(time.Hour)}.withClaims()
+	if expired.valid(now) || expired.DateTimeExpires.Unix() != 1760786400 || expired.DateTimeIssued.Unix() != 1760700000 {
+		t.Errorf("expired = %+v; want the times of the claims", expired)
+	}
+
+	opaque := JWT{Token: "opaque", DateTimeExpires: now.Add(time.Hour)}.withClaims()
+	if !opaque.valid(now) || !opaque.DateTimeExpires.Equal(now.Add(time.Hour)) {
+		t.Errorf("opaque = %+v; want it unchanged", opaque)
+	}
+
+	if (JWT{}).withClaims().valid(now) {
+		t.Error("a blank token is valid")
+	}
+}
+
+// The token is renewed the margin before its exp; a token saved
+// in the TokenCache (i.e. by another instance) is used if it
+// expires later.
+func TestTokenSourceReload(t *testing.T) {
+
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	jwt := func(exp time.Time) JWT {
+		return JWT{Token: testJWT(fmt.Sprintf(`{"iat":%d,"exp":%d}`, now.Unix(), exp.Unix()))}
+	}
+
+	cache := NewMemoryTokenCache()
+	cache.Save(ctx, jwt(now.Add(30*time.Minute)))
+
+	ts := newTokenSource(&IcannAPI{TokenCache: cache, Logger: NewLogger(io.Discard, "", 0)}, time.Hour)
+
+	if got := ts.Expiry(); !got.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("Expiry = %v; want the exp of the cached token", got)
+	}
+	if got := ts.refreshAt(); !got.Equal(now.Add(-30 * time.Minute)) {
+		t.Errorf("refreshAt = %v; want an hour before exp", got)
+	}
+
+	// an older token does not replace the current one
+	cache.Save(ctx, jwt(now.Add(10*time.Minute)))
+	if got := ts.reload(); !got.DateTimeExpires.Equal(now.Add(30 * time.Minute)) {
+		t.Errorf("reload = %v; want the current token", got.DateTimeExpires)
+	}
+
+	// renewed by another instance; no authentication
+	newer := jwt(now.Add(24 * time.Hour))
+	cache.Save(ctx, newer)
+	tok, err := ts.Token(ctx)
+	if err != nil || tok.Token != newer.Token {
+		t.Fatalf("Token = %v, %v; want the token of the cache", tok.DateTimeExpires, err)
+	}
+	if got := ts.refreshAt(); !got.Equal(now.Add(23 * time.Hour)) {
+		t.Errorf("refreshAt = %v; want an hour before the new exp", got)
+	}
+}
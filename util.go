@@ -1,6 +1,7 @@
 package icannclient
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/md5"
@@ -10,19 +11,38 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"math"
 	"os"
-	"os/exec"
-	"strconv"
 	"strings"
 	"time"
 )
 
+// errSaltPhraseBlank is returned by encryptEnvVars when there
+// is no salt phrase; the values are then left as they are.
+var errSaltPhraseBlank = errors.New("SALT_PHRASE is blank")
+
+// ConsoleClearLastLine clears the last line of the console;
+// nothing is written if stdout is not a terminal.
 func ConsoleClearLastLine() {
+	if !isTerminal(os.Stdout) {
+		return
+	}
 	fmt.Println("")
 	fmt.Print("\033[1A\033[K")
 }
+
+// isTerminal returns true if w is a terminal (character device).
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
 func encryptEnvVars(envFile string) error {
 	if !FileOrDirExists(envFile) {
 		return fmt.Errorf("file: %s does not exist", envFile)
@@ -46,9 +66,7 @@ func encryptEnvVars(envFile string) error {
 				if lines[i] == "" || strings.HasPrefix(lines[i], "#") {
 					continue
 				}
-				v := strings.Split(lines[i], "=")
-				key := v[0]
-				value := v[1]
+				key, value, _ := strings.Cut(lines[i], "=")
 				if key == "SALT_PHRASE" {
 					saltValuePlain = value
 					break
@@ -58,7 +76,7 @@ func encryptEnvVars(envFile string) error {
 		if saltValuePlain == "" {
 			// still blank; salt value is needed, whether set in
 			// file or exported on the machine-level
-			return errors.New("SALT_PHRASE is blank")
+			return errSaltPhraseBlank
 		}
 		// if saltValuePlain is already encrypted, bail out
 		_, err := hex.DecodeString(saltValuePlain)
@@ -73,7 +91,7 @@ func encryptEnvVars(envFile string) error {
 		if err != nil {
 			// stop the show if there is any error on
 			// encryption
-			log.Fatal(err)
+			return err
 		}
 		s := hex.EncodeToString(enc)
 		m["SALT_PHRASE"] = s
@@ -84,9 +102,7 @@ func encryptEnvVars(envFile string) error {
 			if lines[i] == "" || strings.HasPrefix(lines[i], "#") {
 				continue
 			}
-			v := strings.Split(lines[i], "=")
-			key := v[0]
-			value := v[1]
+			key, value, _ := strings.Cut(lines[i], "=")
 			if key == "SALT_PHRASE" {
 				continue
 			}
@@ -94,7 +110,7 @@ func encryptEnvVars(envFile string) error {
 			if err != nil {
 				// stop the show if there is any error on
 				// encryption
-				log.Fatal(err)
+				return err
 			}
 			s := hex.EncodeToString(enc)
 			m[key] = s
@@ -106,8 +122,7 @@ func encryptEnvVars(envFile string) error {
 				newLines = append(newLines, lines[i])
 				continue
 			}
-			v := strings.Split(lines[i], "=")
-			key := v[0]
+			key, _, _ := strings.Cut(lines[i], "=")
 			str := fmt.Sprintf("%s=%s", key, m[key])
 
 			newLines = append(newLines, str)
@@ -115,7 +130,7 @@ func encryptEnvVars(envFile string) error {
 		f, err := os.Create(envFile)
 		if err != nil {
 			// stop the show; can't re-create the file!
-			log.Fatal(err)
+			return err
 		}
 		defer f.Close()
 
@@ -123,12 +138,12 @@ func encryptEnvVars(envFile string) error {
 			_, err := f.WriteString(line + "\n")
 			if err != nil {
 				// stop the show; must be able to write to the env file
-				log.Fatal(err)
+				return err
 			}
 		}
 	} else {
 		// sotp the show if there an error reading the env file.
-		log.Fatal(err)
+		return err
 	}
 
 	return nil
@@ -142,7 +157,9 @@ func SetEnvFromFile(envFile string) error {
 		return fmt.Errorf("%s does not exist", envFile)
 	}
 
-	encryptEnvVars(envFile)
+	if err := encryptEnvVars(envFile); err != nil && err != errSaltPhraseBlank {
+		return err
+	}
 
 	b, err := ioutil.ReadFile(envFile)
 	if err != nil {
@@ -165,11 +182,11 @@ func SetEnvFromFile(envFile string) error {
 		if key == "SALT_PHRASE" {
 			bx, err := hex.DecodeString(value)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			bValue, err := DecryptLight(bx, "")
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			saltValue = string(bValue)
 			os.Setenv(key, string(bValue))
@@ -287,42 +304,17 @@ func FileOrDirExists(path string) bool {
 	return !os.IsNotExist(err)
 }
 
-// getFreeDiskSpace reads the output of df -h /
-// and returned the free disk-space on the system in GB (linux only).
-func getFreeDiskSpace() float64 {
-
-	var freedksp float64
-
-	lsCmd := exec.Command("bash", "-c", " df -h /")
-	lsOut, _ := lsCmd.Output()
-	values := strings.Split(string(lsOut), "\n")
-
-	for i := 1; i < len(values); i++ {
-		oneLine := values[i]
-
-		v := strings.Split(oneLine, " ")
-
-		src := v[0]
-
-		isDev := strings.HasPrefix(src, "/dev")
-		if !isDev || strings.Contains(src, "/loop") {
-			continue
+// splitEnvList splits a comma-separated value of an
+// env variable; blank items are removed.
+func splitEnvList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
 		}
-
-		for i := 0; i < 5; i++ {
-			oneLine = strings.ReplaceAll(oneLine, "  ", " ")
-		}
-		v = strings.Split(oneLine, " ")
-		avail := v[3]
-		avail = avail[:len(avail)-1]
-		freedksp, _ = strconv.ParseFloat(avail, 64)
-		if v[3][len(v[3])-1:] == "T" {
-			freedksp = freedksp * 1024
-		}
-		break
 	}
-
-	return freedksp
+	return list
 }
 
 // itemExists is used to check for duplicates. It returns true
@@ -341,12 +333,16 @@ func roundNumber(n float64, percision uint32) float64 {
 	return math.Round(n*math.Pow(10, float64(percision))) / math.Pow(10, float64(percision))
 }
 
-// getMaxFreeDiskForZoneFile return the amount of
-// free-disk-space reported by the system minus 10%
-func getMaxFreeDiskForZoneFile() float64 {
-
-	freeDisk := getFreeDiskSpace()
-	workbenchRatio := freeDisk - (freeDisk * 0.1)
-
-	return workbenchRatio
+// sleepContext pauses for d, or until ctx is done;
+// in which case ctx.Err() is returned.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
 }
@@ -0,0 +1,64 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// An env file is encrypted on the first read; the values (with '='
+// in them) must come back the same on the next read.
+func TestSetEnvFromFileKeepsValues(t *testing.T) {
+
+	values := map[string]string{
+		"TLD_SCHEDULES": "com=24h@02:00-06:00;*=7d",
+		"RETENTION":     "com=7d,4w;*=30d",
+		"USER_AGENT":    "test / 1.0",
+	}
+
+	// restored when the test ends
+	t.Setenv("SALT_PHRASE", "")
+	for k := range values {
+		t.Setenv(k, "")
+	}
+
+	envFile := filepath.Join(t.TempDir(), "icann.env")
+	content := "# comment\nSALT_PHRASE=pepper\n"
+	for k, v := range values {
+		content += k + "=" + v + "\n"
+	}
+	if err := os.WriteFile(envFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for pass := 1; pass <= 2; pass++ {
+		os.Setenv("SALT_PHRASE", "")
+		if err := SetEnvFromFile(envFile); err != nil {
+			t.Fatalf("pass %d: %v", pass, err)
+		}
+		for k, v := range values {
+			if got := os.Getenv(k); got != v {
+				t.Errorf("pass %d: %s = %q; want %q", pass, k, got, v)
+			}
+		}
+	}
+
+	b, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range values {
+		if strings.Contains(string(b), v) {
+			t.Errorf("%q is not encrypted in the env file", v)
+		}
+	}
+
+	if _, err := parseSchedules(os.Getenv("TLD_SCHEDULES")); err != nil {
+		t.Error(err)
+	}
+	if _, err := parseRetention(os.Getenv("RETENTION")); err != nil {
+		t.Error(err)
+	}
+}
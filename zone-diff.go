@@ -0,0 +1,426 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	ZoneChangeAdded   = "added"
+	ZoneChangeDeleted = "deleted"
+	ZoneChangeChanged = "changed"
+
+	DiffFormatNDJSON = "ndjson"
+	DiffFormatCSV    = "csv"
+)
+
+// ZoneChange is a delegation that was added, deleted, or
+// changed (NS or DS set) between two snapshots of a zone.
+type ZoneChange struct {
+	Domain string   `json:"domain"`
+	Change string   `json:"change"`
+	OldNS  []string `json:"old_ns,omitempty"`
+	NewNS  []string `json:"new_ns,omitempty"`
+	OldDS  []string `json:"old_ds,omitempty"`
+	NewDS  []string `json:"new_ds,omitempty"`
+}
+
+// ZoneDiffStats is the summary of a diff.
+type ZoneDiffStats struct {
+	OldDelegations int
+	NewDelegations int
+	Added          int
+	Deleted        int
+	Changed        int
+}
+
+// ZoneDiffOptions controls the external sort of a diff.
+type ZoneDiffOptions struct {
+	// TempDir is where the sorted chunks are written;
+	// default is the system temp-dir.
+	TempDir string
+
+	// MaxMemoryBytes is the (approximate) amount of memory used
+	// to sort each snapshot; default is 64 MB.
+	MaxMemoryBytes int
+}
+
+// ZoneDiffWriter writes the changes found by DiffZoneFiles.
+type ZoneDiffWriter interface {
+	WriteChange(ch ZoneChange) error
+	Flush() error
+}
+
+// delegation is the NS and DS set of a domain.
+type delegation struct {
+	name string
+	ns   []string
+	ds   []string
+}
+
+// DiffZoneFiles compares two snapshots of the same TLD (e.g.
+// 2026-10-16-com.zone.gz and 2026-10-17-com.zone.gz) and writes
+// the added, deleted, and changed delegations to w; in domain order.
+// Each snapshot is sorted externally, so memory use is bounded
+// by opt.MaxMemoryBytes regardless of the size of the zone.
+func DiffZoneFiles(oldFilePath string, newFilePath string, w ZoneDiffWriter, opt ZoneDiffOptions) (ZoneDiffStats, error) {
+
+	var stats ZoneDiffStats
+
+	oldSorter := newExternalSorter(opt.TempDir, opt.MaxMemoryBytes)
+	defer oldSorter.Close()
+	newSorter := newExternalSorter(opt.TempDir, opt.MaxMemoryBytes)
+	defer newSorter.Close()
+
+	if err := addDelegationLines(oldFilePath, oldSorter); err != nil {
+		return stats, err
+	}
+	if err := addDelegationLines(newFilePath, newSorter); err != nil {
+		return stats, err
+	}
+
+	oldIt, err := oldSorter.Sort()
+	if err != nil {
+		return stats, err
+	}
+	defer oldIt.Close()
+	newIt, err := newSorter.Sort()
+	if err != nil {
+		return stats, err
+	}
+	defer newIt.Close()
+
+	oldDel := &delegationIterator{it: oldIt}
+	newDel := &delegationIterator{it: newIt}
+
+	o, err := oldDel.Next()
+	if err != nil {
+		return stats, err
+	}
+	n, err := newDel.Next()
+	if err != nil {
+		return stats, err
+	}
+
+	for o != nil || n != nil {
+		var ch *ZoneChange
+
+		switch {
+		case n == nil || (o != nil && o.name < n.name):
+			stats.OldDelegations++
+			ch = &ZoneChange{Domain: o.name, Change: ZoneChangeDeleted, OldNS: o.ns, OldDS: o.ds}
+			stats.Deleted++
+			if o, err = oldDel.Next(); err != nil {
+				return stats, err
+			}
+
+		case o == nil || n.name < o.name:
+			stats.NewDelegations++
+			ch = &ZoneChange{Domain: n.name, Change: ZoneChangeAdded, NewNS: n.ns, NewDS: n.ds}
+			stats.Added++
+			if n, err = newDel.Next(); err != nil {
+				return stats, err
+			}
+
+		default:
+			stats.OldDelegations++
+			stats.NewDelegations++
+			if !equalStrings(o.ns, n.ns) || !equalStrings(o.ds, n.ds) {
+				ch = &ZoneChange{Domain: n.name, Change: ZoneChangeChanged,
+					OldNS: o.ns, NewNS: n.ns, OldDS: o.ds, NewDS: n.ds}
+				stats.Changed++
+			}
+			if o, err = oldDel.Next(); err != nil {
+				return stats, err
+			}
+			if n, err = newDel.Next(); err != nil {
+				return stats, err
+			}
+		}
+
+		if ch != nil {
+			if err = w.WriteChange(*ch); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	return stats, w.Flush()
+}
+
+// addDelegationLines adds a line for each NS and DS record of
+// a zone file (except those of the zone apex) to the sorter.
+// The line format is: <domain>\t<NS|DS>\t<rdata>
+func addDelegationLines(filePath string, s *externalSorter) error {
+
+	apex := fqdn(getTLDFromZoneFileName(filePath))
+
+	return ParseZoneFile(filePath, func(rec ZoneRecord) error {
+		switch r := rec.(type) {
+		case *SOARecord:
+			apex = r.Name
+		case *NSRecord:
+			if r.Name != apex {
+				return s.Add(r.Name + "\tNS\t" + r.Host)
+			}
+		case *DSRecord:
+			if r.Name != apex {
+				return s.Add(fmt.Sprintf("%s\tDS\t%d %d %d %s", r.Name, r.KeyTag, r.Algorithm, r.DigestType, r.Digest))
+			}
+		}
+		return nil
+	})
+}
+
+// delegationIterator groups the sorted lines by domain.
+type delegationIterator struct {
+	it      lineIterator
+	pending []string
+	done    bool
+}
+
+// Next returns the next delegation; nil at the end.
+func (d *delegationIterator) Next() (*delegation, error) {
+
+	var del *delegation
+
+	for {
+		var v []string
+		if d.pending != nil {
+			v = d.pending
+			d.pending = nil
+		} else {
+			if d.done {
+				return del, nil
+			}
+			line, err := d.it.Next()
+			if err == io.EOF {
+				d.done = true
+				return del, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			v = strings.SplitN(line, "\t", 3)
+			if len(v) != 3 {
+				continue
+			}
+		}
+
+		if del == nil {
+			del = &delegation{name: v[0]}
+		} else if del.name != v[0] {
+			d.pending = v
+			return del, nil
+		}
+
+		// lines are sorted; so duplicates are adjacent
+		if v[1] == "NS" {
+			if len(del.ns) == 0 || del.ns[len(del.ns)-1] != v[2] {
+				del.ns = append(del.ns, v[2])
+			}
+		} else {
+			if len(del.ds) == 0 || del.ds[len(del.ds)-1] != v[2] {
+				del.ds = append(del.ds, v[2])
+			}
+		}
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// NewNDJSONDiffWriter writes one JSON object per change.
+func NewNDJSONDiffWriter(w io.Writer) ZoneDiffWriter {
+	bw := bufio.NewWriter(w)
+	return &ndjsonDiffWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+type ndjsonDiffWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (nw *ndjsonDiffWriter) WriteChange(ch ZoneChange) error {
+	return nw.enc.Encode(ch)
+}
+
+func (nw *ndjsonDiffWriter) Flush() error {
+	return nw.w.Flush()
+}
+
+// NewCSVDiffWriter writes the changes as CSV with a header row;
+// the NS and DS sets are separated by spaces within their column.
+func NewCSVDiffWriter(w io.Writer) ZoneDiffWriter {
+	return &csvDiffWriter{w: csv.NewWriter(w)}
+}
+
+type csvDiffWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (cw *csvDiffWriter) WriteChange(ch ZoneChange) error {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		if err := cw.w.Write([]string{"domain", "change", "old_ns", "new_ns", "old_ds", "new_ds"}); err != nil {
+			return err
+		}
+	}
+	return cw.w.Write([]string{ch.Domain, ch.Change, strings.Join(ch.OldNS, " "), strings.Join(ch.NewNS, " "),
+		strings.Join(ch.OldDS, " "), strings.Join(ch.NewDS, " ")})
+}
+
+func (cw *csvDiffWriter) Flush() error {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.w.Write([]string{"domain", "change", "old_ns", "new_ns", "old_ds", "new_ds"})
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// parseZoneFileName splits the name of a downloaded zone file into
+// its date and TLD; e.g. 2026-10-17-com.zone.gz => 2026-10-17, com.
+func parseZoneFileName(fileName string) (string, string, bool) {
+
+	if !strings.HasSuffix(fileName, ".zone.gz") || len(fileName) < 12 {
+		return "", "", false
+	}
+	if fileName[4] != '-' || fileName[7] != '-' || fileName[10] != '-' {
+		return "", "", false
+	}
+
+	return fileName[:10], strings.TrimSuffix(fileName[11:], ".zone.gz"), true
+}
+
+// findPreviousZoneFile returns the path of the latest snapshot
+// of a TLD that is older than date (YYYY-MM-DD); blank if none.
+func findPreviousZoneFile(dir string, tld string, date string) (string, error) {
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var dates []string
+	for i := 0; i < len(files); i++ {
+		d, t, ok := parseZoneFileName(files[i].Name())
+		if ok && t == tld && d < date {
+			dates = append(dates, d)
+		}
+	}
+	if len(dates) == 0 {
+		return "", nil
+	}
+	sort.Strings(dates)
+
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.zone.gz", dates[len(dates)-1], tld)), nil
+}
+
+// diffZoneFilesOf diffs each of the zone files (i.e. those downloaded
+// in a cycle) against the previous snapshot of the same TLD. The result
+// is written to <DiffDir>/<date>-<tld>.diff.<ndjson|csv>; existing
+// results are not re-created.
+func (c *CzdsAPI) diffZoneFilesOf(filePaths []string) error {
+
+	// the diff reads the zone files from disk
+	ls, ok := c.icann.ZoneStore.(*LocalZoneStore)
+	if !ok {
+		c.icann.logger().Warn("diff skipped; the zone files are not in a local store")
+		return nil
+	}
+	if len(filePaths) == 0 {
+		return nil
+	}
+
+	diffDir := c.icann.DiffDir
+	if diffDir == "" {
+		diffDir = filepath.Join(c.icann.AppDataDir, "diffs")
+	}
+	if err := os.MkdirAll(diffDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	format := c.icann.DiffFormat
+	if format != DiffFormatCSV {
+		format = DiffFormatNDJSON
+	}
+
+	for i := 0; i < len(filePaths); i++ {
+		fileName := filepath.Base(filePaths[i])
+		date, tld, ok := parseZoneFileName(fileName)
+		if !ok {
+			continue
+		}
+
+		outPath := filepath.Join(diffDir, fmt.Sprintf("%s-%s.diff.%s", date, tld, format))
+		if FileOrDirExists(outPath) {
+			continue
+		}
+
+		prevPath, err := findPreviousZoneFile(ls.Dir, tld, date)
+		if err != nil {
+			return err
+		}
+		if prevPath == "" {
+			continue
+		}
+
+		newPath := filepath.Join(ls.Dir, fileName)
+		if err = writeZoneDiffFile(prevPath, newPath, outPath, format); err != nil {
+			c.icann.logger().Warn("diff failed", "tld", tld, "error", err)
+			continue
+		}
+
+		c.icann.logger().Info("diff written", "tld", tld, "path", outPath)
+	}
+
+	return nil
+}
+
+// writeZoneDiffFile diffs two snapshots into outPath;
+// the file is renamed into place when complete.
+func writeZoneDiffFile(oldPath string, newPath string, outPath string, format string) error {
+
+	tempPath := outPath + partFileExt
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	var w ZoneDiffWriter
+	if format == DiffFormatCSV {
+		w = NewCSVDiffWriter(f)
+	} else {
+		w = NewNDJSONDiffWriter(f)
+	}
+
+	_, err = DiffZoneFiles(oldPath, newPath, w, ZoneDiffOptions{TempDir: filepath.Dir(outPath)})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return os.Rename(tempPath, outPath)
+}
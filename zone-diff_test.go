@@ -0,0 +1,195 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kambahr/go-icann-api-client/czdstest"
+)
+
+const (
+	testOldZone = "com.\t900\tIN\tSOA\ta.nic.com. h.nic.com. 1 1800 900 604800 86400\n" +
+		"com.\t172800\tIN\tNS\ta.nic.com.\n" +
+		"alpha.com.\t172800\tIN\tNS\tns1.alpha.net.\n" +
+		"bravo.com.\t172800\tIN\tNS\tns2.bravo.net.\n" +
+		"bravo.com.\t172800\tIN\tNS\tns1.bravo.net.\n" +
+		"charlie.com.\t172800\tIN\tNS\tns1.charlie.net.\n" +
+		"charlie.com.\t86400\tIN\tDS\t1234 13 2 AABB\n" +
+		"echo.com.\t172800\tIN\tNS\tns1.echo.net.\n"
+
+	testNewZone = "com.\t900\tIN\tSOA\ta.nic.com. h.nic.com. 2 1800 900 604800 86400\n" +
+		"com.\t172800\tIN\tNS\tb.nic.com.\n" +
+		"bravo.com.\t172800\tIN\tNS\tns1.bravo.net.\n" +
+		"bravo.com.\t172800\tIN\tNS\tns3.bravo.net.\n" +
+		"charlie.com.\t172800\tIN\tNS\tns1.charlie.net.\n" +
+		"charlie.com.\t86400\tIN\tDS\t5678 13 2 ccdd\n" +
+		"delta.com.\t172800\tIN\tNS\tns1.delta.net.\n" +
+		"echo.com.\t172800\tIN\tNS\tns1.echo.net.\n"
+)
+
+// writeTestZones writes the old and new snapshots of com
+// to dir; and returns their paths.
+func writeTestZones(t *testing.T, dir string, oldDate string, newDate string) (string, string) {
+	t.Helper()
+
+	oldPath := filepath.Join(dir, oldDate+"-com.zone.gz")
+	newPath := filepath.Join(dir, newDate+"-com.zone.gz")
+	if err := os.WriteFile(oldPath, czdstest.GzipZone(testOldZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newPath, czdstest.GzipZone(testNewZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return oldPath, newPath
+}
+
+// changeRecorder keeps the changes written by DiffZoneFiles.
+type changeRecorder struct {
+	changes []ZoneChange
+	flushed bool
+}
+
+func (r *changeRecorder) WriteChange(ch ZoneChange) error {
+	r.changes = append(r.changes, ch)
+	return nil
+}
+
+func (r *changeRecorder) Flush() error {
+	r.flushed = true
+	return nil
+}
+
+// The added, deleted and changed (NS or DS) delegations are found
+// in domain order; the apex and unchanged delegations are not.
+func TestDiffZoneFiles(t *testing.T) {
+
+	dir := t.TempDir()
+	oldPath, newPath := writeTestZones(t, dir, "2026-10-16", "2026-10-17")
+
+	// a few lines per chunk
+	sortDir := t.TempDir()
+	r := &changeRecorder{}
+	stats, err := DiffZoneFiles(oldPath, newPath, r, ZoneDiffOptions{TempDir: sortDir, MaxMemoryBytes: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"{alpha.com. deleted [ns1.alpha.net.] [] [] []}",
+		"{bravo.com. changed [ns1.bravo.net. ns2.bravo.net.] [ns1.bravo.net. ns3.bravo.net.] [] []}",
+		"{charlie.com. changed [ns1.charlie.net.] [ns1.charlie.net.] [1234 13 2 AABB] [5678 13 2 CCDD]}",
+		"{delta.com. added [] [ns1.delta.net.] [] []}",
+	}
+	var got []string
+	for _, ch := range r.changes {
+		got = append(got, fmt.Sprint(ch))
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("changes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !r.flushed {
+		t.Error("the writer is not flushed")
+	}
+
+	wantStats := ZoneDiffStats{OldDelegations: 4, NewDelegations: 4, Added: 1, Deleted: 1, Changed: 2}
+	if stats != wantStats {
+		t.Errorf("stats = %+v; want %+v", stats, wantStats)
+	}
+
+	if files, _ := os.ReadDir(sortDir); len(files) != 0 {
+		t.Errorf("%d temp files are left behind", len(files))
+	}
+}
+
+func TestDiffWriters(t *testing.T) {
+
+	changes := []ZoneChange{
+		{Domain: "alpha.com.", Change: ZoneChangeDeleted, OldNS: []string{"ns1.alpha.net."}},
+		{Domain: "bravo.com.", Change: ZoneChangeChanged, OldNS: []string{"ns1.bravo.net.", "ns2.bravo.net."},
+			NewNS: []string{"ns1.bravo.net."}, NewDS: []string{"1 13 2 AA"}},
+	}
+
+	for _, tc := range []struct {
+		format string
+		want   string
+		empty  string
+	}{
+		{
+			format: DiffFormatNDJSON,
+			want: `{"domain":"alpha.com.","change":"deleted","old_ns":["ns1.alpha.net."]}` + "\n" +
+				`{"domain":"bravo.com.","change":"changed","old_ns":["ns1.bravo.net.","ns2.bravo.net."],"new_ns":["ns1.bravo.net."],"new_ds":["1 13 2 AA"]}` + "\n",
+			empty: "",
+		},
+		{
+			format: DiffFormatCSV,
+			want: "domain,change,old_ns,new_ns,old_ds,new_ds\n" +
+				"alpha.com.,deleted,ns1.alpha.net.,,,\n" +
+				"bravo.com.,changed,ns1.bravo.net. ns2.bravo.net.,ns1.bravo.net.,,1 13 2 AA\n",
+			empty: "domain,change,old_ns,new_ns,old_ds,new_ds\n",
+		},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+
+			newWriter := NewNDJSONDiffWriter
+			if tc.format == DiffFormatCSV {
+				newWriter = NewCSVDiffWriter
+			}
+
+			var buf bytes.Buffer
+			w := newWriter(&buf)
+			for _, ch := range changes {
+				if err := w.WriteChange(ch); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("output:\n%s\nwant:\n%s", buf.String(), tc.want)
+			}
+
+			// no changes
+			buf.Reset()
+			if err := newWriter(&buf).Flush(); err != nil || buf.String() != tc.empty {
+				t.Errorf("output without changes = %q, %v; want %q", buf.String(), err, tc.empty)
+			}
+		})
+	}
+}
+
+// The zone files of a cycle are diffed by their own date; a cycle
+// that ends after midnight still diffs the files of the day before.
+func TestDiffZoneFilesOf(t *testing.T) {
+
+	s := czdstest.NewServer()
+	defer s.Close()
+
+	cnf := testConfig(t, s)
+	cnf.DiffFormat = DiffFormatCSV
+	c, _ := newTestClient(t, cnf)
+
+	dir := c.icann.AppDataDir
+	_, newPath := writeTestZones(t, dir, "2020-01-01", "2020-01-02")
+
+	if err := c.diffZoneFilesOf([]string{newPath}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "diffs", "2020-01-02-com.diff.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 5 {
+		t.Errorf("the diff file has %d lines; want 5 (the header and 4 changes)", n)
+	}
+	if files, _ := os.ReadDir(filepath.Join(dir, "diffs")); len(files) != 1 {
+		t.Errorf("the diff dir has %d files; want 1", len(files))
+	}
+}
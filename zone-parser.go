@@ -0,0 +1,642 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxZoneLineLength is the longest line (or parenthesized
+// record) that ZoneReader accepts.
+const maxZoneLineLength = 1024 * 1024
+
+// ZoneReader reads the records of a zone file (master-file format)
+// one at a time; memory use does not depend on the size of the file.
+type ZoneReader struct {
+	sc      *bufio.Scanner
+	closers []io.Closer
+
+	origin    string
+	ttl       uint32
+	hasTTL    bool
+	lastName  string
+	lineNo    int
+	recLineNo int
+}
+
+// OpenZoneFile opens a zone file downloaded by DownloadZoneFile (i.e.
+// 2026-10-17-com.zone.gz). The file is decompressed on the fly if it
+// is gzipped; the initial $ORIGIN is the TLD in the file name.
+// Callers must call Close when done.
+func OpenZoneFile(filePath string) (*ZoneReader, error) {
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := NewZoneReader(f, getTLDFromZoneFileName(filePath))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	zr.closers = append(zr.closers, f)
+
+	return zr, nil
+}
+
+// NewZoneReader returns a ZoneReader that reads from r; gzipped
+// input is detected and decompressed. origin is the initial $ORIGIN
+// (e.g. com); it can be blank if all names are fully-qualified.
+func NewZoneReader(r io.Reader, origin string) (*ZoneReader, error) {
+
+	var zr ZoneReader
+
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	// gzip magic-number
+	if b, err := br.Peek(2); err == nil && b[0] == 0x1f && b[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		zr.closers = append(zr.closers, gz)
+		zr.sc = bufio.NewScanner(gz)
+	} else {
+		zr.sc = bufio.NewScanner(br)
+	}
+
+	zr.sc.Buffer(make([]byte, 64*1024), maxZoneLineLength)
+	zr.origin = fqdn(strings.ToLower(origin))
+
+	return &zr, nil
+}
+
+// ParseZoneFile calls fn for each record in the zone file; it
+// stops at the first error returned by fn.
+func ParseZoneFile(filePath string, fn func(ZoneRecord) error) error {
+
+	zr, err := OpenZoneFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for {
+		rec, err := zr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the underlying gzip reader and file; if any.
+func (zr *ZoneReader) Close() error {
+	var err error
+	for i := len(zr.closers) - 1; i >= 0; i-- {
+		if e := zr.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	zr.closers = nil
+
+	return err
+}
+
+// Next returns the next record; io.EOF is returned
+// at the end of the file.
+func (zr *ZoneReader) Next() (ZoneRecord, error) {
+	for {
+		fields, ownerBlank, err := zr.readEntry()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(fields[0], "$") && !ownerBlank {
+			if err = zr.directive(fields); err != nil {
+				return nil, zr.parseErr(err)
+			}
+			continue
+		}
+
+		rec, err := zr.record(fields, ownerBlank)
+		if err != nil {
+			return nil, zr.parseErr(err)
+		}
+
+		return rec, nil
+	}
+}
+
+// readEntry reads one logical entry (a line, or lines joined
+// by parentheses) and splits it into fields. ownerBlank is
+// true if the entry starts with a white-space.
+func (zr *ZoneReader) readEntry() ([]string, bool, error) {
+
+	var fields []string
+	var ownerBlank bool
+	depth := 0
+	first := true
+
+	for zr.sc.Scan() {
+		zr.lineNo++
+		line := zr.sc.Text()
+
+		if first {
+			zr.recLineNo = zr.lineNo
+			ownerBlank = len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+			first = false
+		}
+
+		var err error
+		fields, depth, err = splitZoneLine(line, fields, depth)
+		if err != nil {
+			return nil, false, zr.parseErr(err)
+		}
+
+		if depth == 0 {
+			return fields, ownerBlank, nil
+		}
+	}
+
+	if err := zr.sc.Err(); err != nil {
+		return nil, false, err
+	}
+	if depth > 0 {
+		return nil, false, zr.parseErr(fmt.Errorf("unbalanced parentheses"))
+	}
+	if first {
+		return nil, false, io.EOF
+	}
+
+	return fields, ownerBlank, nil
+}
+
+// splitZoneLine appends the fields of one line to fields; comments
+// are dropped and quoted strings are kept as one field (with the
+// quotes). depth is the open-parentheses count.
+func splitZoneLine(line string, fields []string, depth int) ([]string, int, error) {
+
+	var sb strings.Builder
+	inQuote := false
+	inField := false
+
+	flush := func() {
+		if inField {
+			fields = append(fields, sb.String())
+			sb.Reset()
+			inField = false
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+
+		if inQuote {
+			sb.WriteByte(ch)
+			if ch == '\\' && i+1 < len(line) {
+				i++
+				sb.WriteByte(line[i])
+			} else if ch == '"' {
+				inQuote = false
+			}
+			continue
+		}
+
+		switch ch {
+		case ';':
+			flush()
+			return fields, depth, nil
+		case ' ', '\t', '\r':
+			flush()
+		case '(':
+			flush()
+			depth++
+		case ')':
+			flush()
+			depth--
+			if depth < 0 {
+				return fields, depth, fmt.Errorf("unbalanced parentheses")
+			}
+		case '"':
+			inQuote = true
+			inField = true
+			sb.WriteByte(ch)
+		case '\\':
+			inField = true
+			sb.WriteByte(ch)
+			if i+1 < len(line) {
+				i++
+				sb.WriteByte(line[i])
+			}
+		default:
+			inField = true
+			sb.WriteByte(ch)
+		}
+	}
+
+	if inQuote {
+		return fields, depth, fmt.Errorf("unterminated quoted string")
+	}
+	flush()
+
+	return fields, depth, nil
+}
+
+// directive handles $ORIGIN and $TTL.
+func (zr *ZoneReader) directive(fields []string) error {
+
+	if len(fields) < 2 {
+		return fmt.Errorf("%s: missing value", fields[0])
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "$ORIGIN":
+		zr.origin = zr.qualify(fields[1])
+	case "$TTL":
+		ttl, err := parseTTL(fields[1])
+		if err != nil {
+			return err
+		}
+		zr.ttl = ttl
+		zr.hasTTL = true
+	default:
+		return fmt.Errorf("unsupported directive: %s", fields[0])
+	}
+
+	return nil
+}
+
+// record creates a typed record from the fields of an entry.
+func (zr *ZoneReader) record(fields []string, ownerBlank bool) (ZoneRecord, error) {
+
+	var hd RRHeader
+
+	if ownerBlank {
+		if zr.lastName == "" {
+			return nil, fmt.Errorf("no previous owner name")
+		}
+		hd.Name = zr.lastName
+	} else {
+		hd.Name = zr.qualify(fields[0])
+		fields = fields[1:]
+	}
+	zr.lastName = hd.Name
+
+	// [<TTL>] [<class>] <type> or [<class>] [<TTL>] <type>
+	hasTTL := false
+	for len(fields) > 0 {
+		if hd.Class == "" && isZoneClass(fields[0]) {
+			hd.Class = strings.ToUpper(fields[0])
+			fields = fields[1:]
+			continue
+		}
+		if !hasTTL && fields[0][0] >= '0' && fields[0][0] <= '9' {
+			ttl, err := parseTTL(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			hd.TTL = ttl
+			hasTTL = true
+			fields = fields[1:]
+			continue
+		}
+		break
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing record type")
+	}
+	hd.Type = strings.ToUpper(fields[0])
+	rdata := fields[1:]
+
+	if hd.Class == "" {
+		hd.Class = "IN"
+	}
+
+	if hasTTL {
+		if !zr.hasTTL {
+			// without $TTL, the last explicit TTL is the default.
+			zr.ttl = hd.TTL
+		}
+	} else {
+		hd.TTL = zr.ttl
+	}
+
+	return zr.rdata(hd, rdata)
+}
+
+// rdata parses the fields of a record type into its typed record.
+func (zr *ZoneReader) rdata(hd RRHeader, f []string) (ZoneRecord, error) {
+
+	var err error
+	p := zoneFieldParser{}
+
+	switch hd.Type {
+	case "NS":
+		if err = needFields(f, 1); err != nil {
+			return nil, err
+		}
+		return &NSRecord{RRHeader: hd, Host: zr.qualify(f[0])}, nil
+
+	case "A", "AAAA":
+		if err = needFields(f, 1); err != nil {
+			return nil, err
+		}
+		ip, err := netip.ParseAddr(f[0])
+		if err != nil {
+			return nil, err
+		}
+		if hd.Type == "A" {
+			if !ip.Is4() {
+				return nil, fmt.Errorf("invalid A address: %s", f[0])
+			}
+			return &ARecord{RRHeader: hd, IP: ip}, nil
+		}
+		if !ip.Is6() {
+			return nil, fmt.Errorf("invalid AAAA address: %s", f[0])
+		}
+		return &AAAARecord{RRHeader: hd, IP: ip}, nil
+
+	case "DS":
+		if err = needFields(f, 4); err != nil {
+			return nil, err
+		}
+		r := &DSRecord{RRHeader: hd, KeyTag: p.uint16(f[0]), Algorithm: p.uint8(f[1]),
+			DigestType: p.uint8(f[2]), Digest: strings.ToUpper(strings.Join(f[3:], ""))}
+		return r, p.err
+
+	case "DNSKEY":
+		if err = needFields(f, 4); err != nil {
+			return nil, err
+		}
+		r := &DNSKEYRecord{RRHeader: hd, Flags: p.uint16(f[0]), Protocol: p.uint8(f[1]),
+			Algorithm: p.uint8(f[2]), PublicKey: strings.Join(f[3:], "")}
+		return r, p.err
+
+	case "NSEC":
+		if err = needFields(f, 1); err != nil {
+			return nil, err
+		}
+		return &NSECRecord{RRHeader: hd, NextDomain: zr.qualify(f[0]), Types: upperAll(f[1:])}, nil
+
+	case "NSEC3":
+		if err = needFields(f, 5); err != nil {
+			return nil, err
+		}
+		r := &NSEC3Record{RRHeader: hd, HashAlgorithm: p.uint8(f[0]), Flags: p.uint8(f[1]),
+			Iterations: p.uint16(f[2]), Salt: strings.ToUpper(f[3]), NextHashed: strings.ToUpper(f[4]),
+			Types: upperAll(f[5:])}
+		return r, p.err
+
+	case "RRSIG":
+		if err = needFields(f, 9); err != nil {
+			return nil, err
+		}
+		r := &RRSIGRecord{RRHeader: hd, TypeCovered: strings.ToUpper(f[0]), Algorithm: p.uint8(f[1]),
+			Labels: p.uint8(f[2]), OriginalTTL: p.uint32(f[3]), Expiration: p.sigTime(f[4]),
+			Inception: p.sigTime(f[5]), KeyTag: p.uint16(f[6]), SignerName: zr.qualify(f[7]),
+			Signature: strings.Join(f[8:], "")}
+		return r, p.err
+
+	case "SOA":
+		if err = needFields(f, 7); err != nil {
+			return nil, err
+		}
+		r := &SOARecord{RRHeader: hd, MName: zr.qualify(f[0]), RName: zr.qualify(f[1]),
+			Serial: p.uint32(f[2]), Refresh: p.ttl(f[3]), Retry: p.ttl(f[4]),
+			Expire: p.ttl(f[5]), Minimum: p.ttl(f[6])}
+		return r, p.err
+
+	case "TXT":
+		r := &TXTRecord{RRHeader: hd}
+		for _, s := range f {
+			r.Texts = append(r.Texts, unquoteZoneString(s))
+		}
+		return r, nil
+	}
+
+	return &GenericRecord{RRHeader: hd, RData: f}, nil
+}
+
+// qualify returns name as a lowercase fully-qualified name;
+// @ and relative names are completed with the current $ORIGIN.
+func (zr *ZoneReader) qualify(name string) string {
+
+	name = strings.ToLower(name)
+
+	if name == "@" {
+		return zr.origin
+	}
+	if strings.HasSuffix(name, ".") && !strings.HasSuffix(name, "\\.") {
+		return name
+	}
+	if zr.origin == "" || zr.origin == "." {
+		return name + "."
+	}
+
+	return name + "." + zr.origin
+}
+
+func (zr *ZoneReader) parseErr(err error) error {
+	if _, ok := err.(*ZoneParseError); ok {
+		return err
+	}
+	return &ZoneParseError{Line: zr.recLineNo, Err: err}
+}
+
+// zoneFieldParser converts numeric fields; the
+// first error is kept in err.
+type zoneFieldParser struct {
+	err error
+}
+
+func (p *zoneFieldParser) uint(s string, bitSize int) uint64 {
+	n, err := strconv.ParseUint(s, 10, bitSize)
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	return n
+}
+
+func (p *zoneFieldParser) uint8(s string) uint8 {
+	return uint8(p.uint(s, 8))
+}
+
+func (p *zoneFieldParser) uint16(s string) uint16 {
+	return uint16(p.uint(s, 16))
+}
+
+func (p *zoneFieldParser) uint32(s string) uint32 {
+	return uint32(p.uint(s, 32))
+}
+
+func (p *zoneFieldParser) ttl(s string) uint32 {
+	n, err := parseTTL(s)
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	return n
+}
+
+// sigTime parses an RRSIG time; YYYYMMDDHHmmSS or seconds since epoch.
+func (p *zoneFieldParser) sigTime(s string) time.Time {
+	if len(s) == 14 {
+		t, err := time.Parse("20060102150405", s)
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		return t
+	}
+	return time.Unix(int64(p.uint(s, 32)), 0).UTC()
+}
+
+// parseTTL parses a TTL in seconds, or with BIND units (i.e. 1h30m).
+func parseTTL(s string) (uint32, error) {
+
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+
+	var total, cur uint64
+	hasDigit := false
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= '0' && ch <= '9' {
+			cur = cur*10 + uint64(ch-'0')
+			hasDigit = true
+			continue
+		}
+		if !hasDigit {
+			return 0, fmt.Errorf("invalid ttl: %s", s)
+		}
+		switch ch {
+		case 's', 'S':
+		case 'm', 'M':
+			cur *= 60
+		case 'h', 'H':
+			cur *= 3600
+		case 'd', 'D':
+			cur *= 86400
+		case 'w', 'W':
+			cur *= 604800
+		default:
+			return 0, fmt.Errorf("invalid ttl: %s", s)
+		}
+		total += cur
+		cur = 0
+		hasDigit = false
+	}
+	total += cur
+
+	if total > 1<<32-1 {
+		return 0, fmt.Errorf("invalid ttl: %s", s)
+	}
+
+	return uint32(total), nil
+}
+
+func isZoneClass(s string) bool {
+	switch strings.ToUpper(s) {
+	case "IN", "CH", "HS", "CS":
+		return true
+	}
+	return false
+}
+
+func needFields(f []string, n int) error {
+	if len(f) < n {
+		return fmt.Errorf("expected %d rdata fields; got %d", n, len(f))
+	}
+	return nil
+}
+
+func upperAll(v []string) []string {
+	for i := 0; i < len(v); i++ {
+		v[i] = strings.ToUpper(v[i])
+	}
+	return v
+}
+
+// unquoteZoneString removes the quotes of a character-string
+// and resolves its escapes (\" and \DDD).
+func unquoteZoneString(s string) string {
+
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigits(s[i+1:i+4]) {
+			n, _ := strconv.Atoi(s[i+1 : i+4])
+			sb.WriteByte(byte(n))
+			i += 3
+			continue
+		}
+		i++
+		sb.WriteByte(s[i])
+	}
+
+	return sb.String()
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// fqdn adds the trailing dot to a name; if missing.
+func fqdn(name string) string {
+	if name == "" || strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// getTLDFromZoneFileName returns the TLD from the name of a
+// downloaded zone file; e.g. 2026-10-17-com.zone.gz => com.
+func getTLDFromZoneFileName(filePath string) string {
+
+	fName := filepath.Base(filePath)
+	fName = strings.TrimSuffix(fName, ".gz")
+	fName = strings.TrimSuffix(fName, ".zone")
+	fName = strings.TrimSuffix(fName, ".txt")
+
+	// YYYY-MM-DD-
+	if len(fName) > 11 && fName[4] == '-' && fName[7] == '-' && fName[10] == '-' {
+		fName = fName[11:]
+	}
+
+	return strings.ToLower(fName)
+}
@@ -0,0 +1,176 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kambahr/go-icann-api-client/czdstest"
+)
+
+// readZone returns all the records of a zone text (origin com).
+func readZone(t *testing.T, zone string) ([]ZoneRecord, error) {
+	t.Helper()
+
+	zr, err := NewZoneReader(strings.NewReader(zone), "com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	var list []ZoneRecord
+	for {
+		rec, err := zr.Next()
+		if err == io.EOF {
+			return list, nil
+		}
+		if err != nil {
+			return list, err
+		}
+		list = append(list, rec)
+	}
+}
+
+func TestZoneReader(t *testing.T) {
+
+	soa := &SOARecord{RRHeader: RRHeader{Name: "com.", TTL: 900, Class: "IN", Type: "SOA"},
+		MName: "a.gtld-servers.net.", RName: "nstld.verisign-grs.com.",
+		Serial: 1760700000, Refresh: 1800, Retry: 900, Expire: 604800, Minimum: 86400}
+
+	for _, tc := range []struct {
+		name string
+		zone string
+		want []ZoneRecord
+	}{
+		{
+			name: "multi-line SOA",
+			zone: "com. 900 IN SOA a.gtld-servers.net. nstld.verisign-grs.com. (\n" +
+				"\t1760700000 ; serial\n" +
+				"\t30m        ; refresh\n" +
+				"\t900 1w 1d )\n",
+			want: []ZoneRecord{soa},
+		},
+		{
+			name: "relative and @ owners",
+			zone: "@ 172800 IN NS a.gtld-servers.net.\n" +
+				"example 172800 IN NS ns1.example\n" +
+				"$ORIGIN example.com.\n" +
+				"www 3600 IN A 192.0.2.1\n",
+			want: []ZoneRecord{
+				&NSRecord{RRHeader: RRHeader{Name: "com.", TTL: 172800, Class: "IN", Type: "NS"}, Host: "a.gtld-servers.net."},
+				&NSRecord{RRHeader: RRHeader{Name: "example.com.", TTL: 172800, Class: "IN", Type: "NS"}, Host: "ns1.example.com."},
+				&ARecord{RRHeader: RRHeader{Name: "www.example.com.", TTL: 3600, Class: "IN", Type: "A"}, IP: netip.MustParseAddr("192.0.2.1")},
+			},
+		},
+		{
+			name: "inherited owner and TTL",
+			zone: "example.com. 3600 IN NS ns1.example.net.\n" +
+				"\tNS ns2.example.net.\n" +
+				"$TTL 1h\n" +
+				"other.com. IN AAAA 2001:db8::1\n" +
+				"\t60 A 192.0.2.2\n",
+			want: []ZoneRecord{
+				&NSRecord{RRHeader: RRHeader{Name: "example.com.", TTL: 3600, Class: "IN", Type: "NS"}, Host: "ns1.example.net."},
+				&NSRecord{RRHeader: RRHeader{Name: "example.com.", TTL: 3600, Class: "IN", Type: "NS"}, Host: "ns2.example.net."},
+				&AAAARecord{RRHeader: RRHeader{Name: "other.com.", TTL: 3600, Class: "IN", Type: "AAAA"}, IP: netip.MustParseAddr("2001:db8::1")},
+				&ARecord{RRHeader: RRHeader{Name: "other.com.", TTL: 60, Class: "IN", Type: "A"}, IP: netip.MustParseAddr("192.0.2.2")},
+			},
+		},
+		{
+			name: "quoted TXT with ;",
+			zone: `example.com. 300 IN TXT "v=spf1 -all; x" "say \"hi\"" ; comment` + "\n",
+			want: []ZoneRecord{
+				&TXTRecord{RRHeader: RRHeader{Name: "example.com.", TTL: 300, Class: "IN", Type: "TXT"},
+					Texts: []string{"v=spf1 -all; x", `say "hi"`}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readZone(t, tc.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("records:\n%s\nwant:\n%s", recordsString(got), recordsString(tc.want))
+			}
+		})
+	}
+}
+
+// recordsString returns the records one per line; for the errors.
+func recordsString(list []ZoneRecord) string {
+	var sb strings.Builder
+	for _, rec := range list {
+		fmt.Fprintf(&sb, "%+v\n", rec)
+	}
+	return sb.String()
+}
+
+// A ZoneParseError has the line number where the entry starts.
+func TestZoneReaderParseError(t *testing.T) {
+
+	for _, tc := range []struct {
+		name string
+		zone string
+		line int
+	}{
+		{"bad A", "com. 900 IN NS a.nic.com.\n\nexample.com. 60 IN A 2001:db8::1\n", 3},
+		{"bad SOA serial", "; header\ncom. 900 IN SOA a.nic.com. h.nic.com. (\n x 1800 900 604800 86400 )\n", 2},
+		{"unbalanced", "com. 900 IN NS a.nic.com.\ncom. 900 IN SOA a. b. ( 1 2 3 4 5\n", 2},
+		{"no previous owner", "\tNS a.nic.com.\n", 1},
+		{"unterminated quote", "com. 900 IN NS a.nic.com.\nx.com. 60 IN TXT \"abc\n", 2},
+		{"directive", "$INCLUDE other.zone\n", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readZone(t, tc.zone)
+			var pe *ZoneParseError
+			if !errors.As(err, &pe) || pe.Line != tc.line {
+				t.Errorf("err = %v; want a *ZoneParseError at line %d", err, tc.line)
+			}
+		})
+	}
+}
+
+// A gzipped zone is detected and decompressed.
+func TestZoneReaderGzip(t *testing.T) {
+
+	zr, err := NewZoneReader(strings.NewReader(string(czdstest.GzipZone(testZone("com", 3)))), "com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	var types []string
+	for {
+		rec, err := zr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, rec.Header().Type)
+	}
+	if fmt.Sprint(types) != "[SOA NS NS NS NS]" {
+		t.Errorf("types = %v", types)
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+
+	for s, want := range map[string]uint32{"0": 0, "86400": 86400, "1h30m": 5400, "1W": 604800, "2d1s": 172801} {
+		if got, err := parseTTL(s); err != nil || got != want {
+			t.Errorf("parseTTL(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"h", "1x", "99999999999"} {
+		if _, err := parseTTL(s); err == nil {
+			t.Errorf("parseTTL(%q) did not fail", s)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"net/netip"
+	"time"
+)
+
+// ZoneRecord is implemented by all the records returned by ZoneReader;
+// use a type switch to get to the typed record (e.g. *NSRecord).
+type ZoneRecord interface {
+	Header() *RRHeader
+}
+
+// RRHeader holds the fields common to all records. Names are
+// fully-qualified and lowercase; Type and Class are uppercase.
+type RRHeader struct {
+	Name  string // e.g. example.com.
+	TTL   uint32
+	Class string // e.g. IN
+	Type  string // e.g. NS
+}
+
+// Header returns the common fields of a record.
+func (h *RRHeader) Header() *RRHeader {
+	return h
+}
+
+type NSRecord struct {
+	RRHeader
+	Host string
+}
+
+type ARecord struct {
+	RRHeader
+	IP netip.Addr
+}
+
+type AAAARecord struct {
+	RRHeader
+	IP netip.Addr
+}
+
+type DSRecord struct {
+	RRHeader
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     string // hex, uppercase
+}
+
+type DNSKEYRecord struct {
+	RRHeader
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey string // base64
+}
+
+type NSECRecord struct {
+	RRHeader
+	NextDomain string
+	Types      []string
+}
+
+type NSEC3Record struct {
+	RRHeader
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          string // hex; - for no salt
+	NextHashed    string // base32hex
+	Types         []string
+}
+
+type RRSIGRecord struct {
+	RRHeader
+	TypeCovered string
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  time.Time
+	Inception   time.Time
+	KeyTag      uint16
+	SignerName  string
+	Signature   string // base64
+}
+
+type SOARecord struct {
+	RRHeader
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+type TXTRecord struct {
+	RRHeader
+	Texts []string
+}
+
+// GenericRecord holds any other record type; RData has
+// the fields as they appear in the file.
+type GenericRecord struct {
+	RRHeader
+	RData []string
+}
@@ -0,0 +1,193 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// defaultS3PartSize is the size of the parts of a multipart upload;
+// it is also the memory used by an upload. With the limit of 10,000
+// parts, the largest zone file that can be stored is ~160 GB.
+const defaultS3PartSize = 16 * 1024 * 1024
+
+// S3Config is the configuration of an S3ZoneStore.
+type S3Config struct {
+	// Endpoint is the host[:port] of the S3-compatible service
+	// e.g. s3.amazonaws.com, localhost:9000 (MinIO).
+	Endpoint string
+
+	// Bucket must exist; Prefix is prepended to the names of
+	// the objects (e.g. zone-files/).
+	Bucket string
+	Prefix string
+
+	AccessKey string
+	SecretKey string
+	Region    string
+
+	// Secure uses https.
+	Secure bool
+
+	// PathStyle forces path-style requests (endpoint/bucket/object);
+	// otherwise it is decided by the endpoint.
+	PathStyle bool
+
+	// PartSize is the size of the parts of the multipart uploads;
+	// default is 16 MB.
+	PartSize uint64
+
+	// Transport is the http transport to the service; if nil,
+	// a default transport is used.
+	Transport http.RoundTripper
+}
+
+// S3ZoneStore keeps the zone files in a bucket of an S3-compatible
+// object store (AWS S3, MinIO,...). Downloads are streamed to the
+// bucket with multipart uploads; nothing is staged on the local disk.
+type S3ZoneStore struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+}
+
+// NewS3ZoneStore returns an S3ZoneStore; the bucket is
+// not checked until the first call.
+func NewS3ZoneStore(cnf S3Config) (*S3ZoneStore, error) {
+
+	if cnf.Endpoint == "" || cnf.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+
+	opt := &minio.Options{
+		Creds:     credentials.NewStaticV4(cnf.AccessKey, cnf.SecretKey, ""),
+		Secure:    cnf.Secure,
+		Region:    cnf.Region,
+		Transport: cnf.Transport,
+	}
+	if cnf.PathStyle {
+		opt.BucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(cnf.Endpoint, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &S3ZoneStore{client: client, bucket: cnf.Bucket, prefix: cnf.Prefix, partSize: cnf.PartSize}
+	if s.partSize == 0 {
+		s.partSize = defaultS3PartSize
+	}
+
+	return s, nil
+}
+
+// key returns the object key of name.
+func (s *S3ZoneStore) key(name string) string {
+	return s.prefix + name
+}
+
+// Put streams r to the bucket in parts (the size is not needed); if
+// reading r fails, the multipart upload is aborted and the object is
+// not created.
+func (s *S3ZoneStore) Put(ctx context.Context, name string, r io.Reader) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(name), r, -1,
+		minio.PutObjectOptions{PartSize: s.partSize, ContentType: s3ContentType(name)})
+	return s.err(name, err)
+}
+
+func (s *S3ZoneStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.err(name, err)
+	}
+
+	// GetObject does not send the request until the first read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, s.err(name, err)
+	}
+
+	return obj, nil
+}
+
+func (s *S3ZoneStore) Stat(ctx context.Context, name string) (ZoneObjectInfo, error) {
+	oi, err := s.client.StatObject(ctx, s.bucket, s.key(name), minio.StatObjectOptions{})
+	if err != nil {
+		return ZoneObjectInfo{}, s.err(name, err)
+	}
+	return ZoneObjectInfo{Name: name, Size: oi.Size, ModTime: oi.LastModified}, nil
+}
+
+func (s *S3ZoneStore) List(ctx context.Context, prefix string) ([]ZoneObjectInfo, error) {
+
+	var list []ZoneObjectInfo
+
+	for oi := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.key(prefix)}) {
+		if oi.Err != nil {
+			return nil, oi.Err
+		}
+		name := strings.TrimPrefix(oi.Key, s.prefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		list = append(list, ZoneObjectInfo{Name: name, Size: oi.Size, ModTime: oi.LastModified})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+func (s *S3ZoneStore) Delete(ctx context.Context, name string) error {
+	return s.err(name, s.client.RemoveObject(ctx, s.bucket, s.key(name), minio.RemoveObjectOptions{}))
+}
+
+// FreeSpace returns -1; the space of a bucket is not limited.
+func (s *S3ZoneStore) FreeSpace(ctx context.Context) (int64, error) {
+	return -1, nil
+}
+
+// Link copies oldName to newName on the server; objects over
+// 5 GB are copied in parts.
+func (s *S3ZoneStore) Link(ctx context.Context, oldName string, newName string) error {
+	_, err := s.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(newName)},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.key(oldName)})
+	return s.err(oldName, err)
+}
+
+// err maps the "not found" errors of the service to fs.ErrNotExist.
+func (s *S3ZoneStore) err(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound && resp.Code != "NoSuchBucket" {
+		return fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+
+	return err
+}
+
+// s3ContentType returns the content-type of the objects.
+func s3ContentType(name string) string {
+	if strings.HasSuffix(name, sha256FileExt) {
+		return "text/plain"
+	}
+	if strings.HasSuffix(name, ".gz") {
+		return "application/gzip"
+	}
+	return "application/octet-stream"
+}
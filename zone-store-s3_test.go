@@ -0,0 +1,275 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kambahr/go-icann-api-client/czdstest"
+	"github.com/kambahr/go-icann-api-client/s3test"
+)
+
+const testBucket = "zones"
+
+// newTestS3Store returns a store in testBucket of s; objects
+// are kept under the zone-files/ prefix.
+func newTestS3Store(t *testing.T, s *s3test.Server) *S3ZoneStore {
+
+	store, err := NewS3ZoneStore(S3Config{Endpoint: s.Endpoint, Bucket: testBucket, Prefix: "zone-files/",
+		AccessKey: "key", SecretKey: "secret", PathStyle: true, PartSize: 5 * 1024 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// errAfterReader returns err after n bytes of r.
+type errAfterReader struct {
+	r   io.Reader
+	n   int
+	err error
+}
+
+func (e *errAfterReader) Read(p []byte) (int, error) {
+	if e.n <= 0 {
+		return 0, e.err
+	}
+	if len(p) > e.n {
+		p = p[:e.n]
+	}
+	n, err := e.r.Read(p)
+	e.n -= n
+	return n, err
+}
+
+// checkVerifiers fails the test if the goroutine of a
+// verifyingReader is still running.
+func checkVerifiers(t *testing.T) {
+	t.Helper()
+
+	buf := make([]byte, 1<<20)
+	for i := 0; ; i++ {
+		n := runtime.Stack(buf, true)
+		if !strings.Contains(string(buf[:n]), "newVerifyingReader.func") {
+			return
+		}
+		if i == 100 {
+			t.Fatal("the goroutine of a verifyingReader is left behind")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A zone file of an unknown size is uploaded in parts.
+func TestS3ZoneStorePut(t *testing.T) {
+
+	s := s3test.NewServer(testBucket)
+	defer s.Close()
+
+	store := newTestS3Store(t, s)
+	ctx := context.Background()
+
+	// three parts of the upload; the reader hides the size
+	data := make([]byte, 12*1024*1024)
+	rand.Read(data)
+	if err := store.Put(ctx, "2026-10-17-com.zone.gz", io.MultiReader(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	b, ok := s.Object(testBucket, "zone-files/2026-10-17-com.zone.gz")
+	if !ok || !bytes.Equal(b, data) {
+		t.Fatalf("object: %d bytes (exists: %v); want %d", len(b), ok, len(data))
+	}
+	if n := s.IncompleteUploads(); n != 0 {
+		t.Errorf("incomplete uploads = %d", n)
+	}
+
+	fi, err := store.Stat(ctx, "2026-10-17-com.zone.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name != "2026-10-17-com.zone.gz" || fi.Size != int64(len(data)) {
+		t.Errorf("Stat = %+v", fi)
+	}
+
+	r, err := store.Get(ctx, "2026-10-17-com.zone.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, err := io.ReadAll(r); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("Get: %d bytes, %v", len(b), err)
+	}
+}
+
+// A failed upload (the reader, or the service) does not
+// leave an object, nor an incomplete upload.
+func TestS3ZoneStorePutFails(t *testing.T) {
+
+	s := s3test.NewServer(testBucket)
+	defer s.Close()
+
+	store := newTestS3Store(t, s)
+	ctx := context.Background()
+
+	data := make([]byte, 12*1024*1024)
+	rand.Read(data)
+
+	errVerify := errors.New("verify failed")
+	r := &errAfterReader{r: bytes.NewReader(data), n: 7 * 1024 * 1024, err: errVerify}
+	if err := store.Put(ctx, "2026-10-17-com.zone.gz", r); !errors.Is(err, errVerify) {
+		t.Errorf("Put with a failing reader = %v; want %v", err, errVerify)
+	}
+
+	s.FailUploadsAfter(1)
+	if err := store.Put(ctx, "2026-10-17-com.zone.gz", io.MultiReader(bytes.NewReader(data))); err == nil {
+		t.Error("Put did not fail")
+	}
+
+	if keys := s.Objects(testBucket); len(keys) != 0 {
+		t.Errorf("objects = %v; want none", keys)
+	}
+	if n := s.IncompleteUploads(); n != 0 {
+		t.Errorf("incomplete uploads = %d", n)
+	}
+
+	if _, err := store.Stat(ctx, "2026-10-17-com.zone.gz"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat = %v; want fs.ErrNotExist", err)
+	}
+}
+
+// A download to a store that fails before the end of the stream
+// does not leave the checks (verifyingReader) running.
+func TestS3DownloadFails(t *testing.T) {
+
+	s := s3test.NewServer(testBucket)
+	defer s.Close()
+
+	cz := czdstest.NewServer()
+	defer cz.Close()
+
+	// more than a part; random names do not compress
+	var sb strings.Builder
+	sb.WriteString(testZone("com", 0))
+	b := make([]byte, 16)
+	for i := 0; i < 400000; i++ {
+		rand.Read(b)
+		fmt.Fprintf(&sb, "%x.com.\t172800\tIN\tNS\tns1.example.net.\n", b)
+	}
+	data := czdstest.GzipZone(sb.String())
+	if len(data) <= 6*1024*1024 {
+		t.Fatalf("the zone file is %d bytes; want more than a part", len(data))
+	}
+	cz.AddZone("com", data)
+
+	cnf := testConfig(t, cz)
+	cnf.ZoneStore = newTestS3Store(t, s)
+	c, _ := newTestClient(t, cnf)
+
+	s.FailUploadsAfter(0)
+
+	fp := filepath.Join(c.icann.AppDataDir, "2026-10-17-com.zone.gz")
+	if _, err := c.DownloadZoneFile(fp, cz.DownloadURL("com"), nil); err == nil {
+		t.Fatal("the download did not fail")
+	}
+	if keys := s.Objects(testBucket); len(keys) != 0 {
+		t.Errorf("objects = %v; want none", keys)
+	}
+
+	checkVerifiers(t)
+}
+
+// Link copies an unchanged zone file to the new date on the server.
+func TestS3ZoneStoreLink(t *testing.T) {
+
+	s := s3test.NewServer(testBucket)
+	defer s.Close()
+
+	store := newTestS3Store(t, s)
+	ctx := context.Background()
+
+	data := czdstest.GzipZone(testZone("com", 100))
+	s.PutObject(testBucket, "zone-files/2026-10-16-com.zone.gz", data)
+
+	if err := store.Link(ctx, "2026-10-16-com.zone.gz", "2026-10-17-com.zone.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := s.Object(testBucket, "zone-files/2026-10-17-com.zone.gz"); !ok || !bytes.Equal(b, data) {
+		t.Errorf("the linked object: %d bytes (exists: %v); want %d", len(b), ok, len(data))
+	}
+
+	if err := store.Link(ctx, "2026-10-15-com.zone.gz", "2026-10-18-com.zone.gz"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Link of a missing object = %v; want fs.ErrNotExist", err)
+	}
+}
+
+// The retention policy lists and deletes the zone files (and their
+// .sha256 files) in the bucket.
+func TestS3ZoneStoreRetention(t *testing.T) {
+
+	s := s3test.NewServer(testBucket)
+	defer s.Close()
+
+	cz := czdstest.NewServer()
+	defer cz.Close()
+
+	for _, date := range []string{"2026-10-14", "2026-10-15", "2026-10-16", "2026-10-17"} {
+		for _, tld := range []string{"com", "net"} {
+			name := fmt.Sprintf("zone-files/%s-%s.zone.gz", date, tld)
+			s.PutObject(testBucket, name, []byte(date))
+			s.PutObject(testBucket, name+sha256FileExt, []byte("digest"))
+		}
+	}
+	// not a zone file; left alone
+	s.PutObject(testBucket, "zone-files/notes.txt", []byte("x"))
+
+	cnf := testConfig(t, cz)
+	cnf.ZoneStore = newTestS3Store(t, s)
+	cnf.Retention = []RetentionPolicy{{Pattern: "com", KeepDays: 2}}
+
+	c, _ := newTestClient(t, cnf)
+
+	list, err := cnf.ZoneStore.List(context.Background(), "2026-10-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 16 || list[0].Name != "2026-10-14-com.zone.gz" {
+		t.Errorf("List = %d objects, first %+v; want 16, 2026-10-14-com.zone.gz", len(list), list[0])
+	}
+
+	pruned, err := c.PruneZoneFiles(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pf := range pruned {
+		names = append(names, pf.Name)
+	}
+	if fmt.Sprint(names) != "[2026-10-14-com.zone.gz 2026-10-15-com.zone.gz]" {
+		t.Errorf("pruned = %v", names)
+	}
+
+	want := []string{
+		"zone-files/2026-10-14-net.zone.gz", "zone-files/2026-10-14-net.zone.gz.sha256",
+		"zone-files/2026-10-15-net.zone.gz", "zone-files/2026-10-15-net.zone.gz.sha256",
+		"zone-files/2026-10-16-com.zone.gz", "zone-files/2026-10-16-com.zone.gz.sha256",
+		"zone-files/2026-10-16-net.zone.gz", "zone-files/2026-10-16-net.zone.gz.sha256",
+		"zone-files/2026-10-17-com.zone.gz", "zone-files/2026-10-17-com.zone.gz.sha256",
+		"zone-files/2026-10-17-net.zone.gz", "zone-files/2026-10-17-net.zone.gz.sha256",
+		"zone-files/notes.txt",
+	}
+	if got := s.Objects(testBucket); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("objects = %v;\nwant %v", got, want)
+	}
+}
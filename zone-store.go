@@ -0,0 +1,293 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ZoneStore is where the zone files (and their .sha256 files) are
+// kept; the names are file names e.g. 2026-10-17-com.zone.gz. The
+// default is a LocalZoneStore in Config.ZoneFileDir; see also
+// S3ZoneStore.
+type ZoneStore interface {
+	// Put stores the content of r as name (replacing it; if any). If
+	// reading r fails, name must not be created; so that a partial
+	// (or unverified) zone file is never visible.
+	Put(ctx context.Context, name string, r io.Reader) error
+
+	// Get opens name for reading; callers must close it.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// Stat returns the size and time of name; an error that
+	// matches fs.ErrNotExist if it does not exist.
+	Stat(ctx context.Context, name string) (ZoneObjectInfo, error)
+
+	// List returns the objects whose name starts with prefix;
+	// sorted by name.
+	List(ctx context.Context, prefix string) ([]ZoneObjectInfo, error)
+
+	// Delete removes name; it is not an error if it does not exist.
+	Delete(ctx context.Context, name string) error
+
+	// FreeSpace returns the number of bytes that can be stored;
+	// -1 if there is no (known) limit.
+	FreeSpace(ctx context.Context) (int64, error)
+}
+
+// ZoneLinker is implemented by the stores that can make a copy of an
+// object without transferring its content (a hardlink, or a copy on
+// the server). It is used for the zone files that have not changed
+// since the previous day; other stores get a copy (Get and Put).
+type ZoneLinker interface {
+	Link(ctx context.Context, oldName string, newName string) error
+}
+
+// ZoneObjectInfo describes an object in a ZoneStore.
+type ZoneObjectInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// LocalZoneStore keeps the zone files in a directory; it is the
+// default ZoneStore. Unlike the other stores, the downloads are
+// resumed after an interruption (see DownloadZoneFile).
+type LocalZoneStore struct {
+	Dir string
+
+	// ReserveBytes and ReservePercent (of the volume) are kept
+	// free; the larger of the two applies.
+	ReserveBytes   int64
+	ReservePercent float64
+}
+
+// NewLocalZoneStore returns a LocalZoneStore in dir; 10%
+// of the volume is kept free.
+func NewLocalZoneStore(dir string) *LocalZoneStore {
+	return &LocalZoneStore{Dir: dir, ReservePercent: 10}
+}
+
+// path returns the file path of name.
+func (s *LocalZoneStore) path(name string) string {
+	return filepath.Join(s.Dir, filepath.Base(name))
+}
+
+func (s *LocalZoneStore) Put(ctx context.Context, name string, r io.Reader) error {
+
+	tmpPath := s.path(name) + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, s.path(name))
+}
+
+func (s *LocalZoneStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+func (s *LocalZoneStore) Stat(ctx context.Context, name string) (ZoneObjectInfo, error) {
+	fi, err := os.Stat(s.path(name))
+	if err != nil {
+		return ZoneObjectInfo{}, err
+	}
+	return ZoneObjectInfo{Name: filepath.Base(name), Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *LocalZoneStore) List(ctx context.Context, prefix string) ([]ZoneObjectInfo, error) {
+
+	files, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []ZoneObjectInfo
+	for i := 0; i < len(files); i++ {
+		if files[i].IsDir() || !strings.HasPrefix(files[i].Name(), prefix) {
+			continue
+		}
+		// (a symlink is reported with the size of its target)
+		fi, err := os.Stat(filepath.Join(s.Dir, files[i].Name()))
+		if err != nil {
+			continue
+		}
+		list = append(list, ZoneObjectInfo{Name: files[i].Name(), Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+// Delete removes name. The symlinks to it (see Link) keep their
+// content; the file is moved to the first of them, and the rest
+// are pointed at it.
+func (s *LocalZoneStore) Delete(ctx context.Context, name string) error {
+
+	p := s.path(name)
+
+	fi, err := os.Lstat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if links := s.symlinksTo(p, fi); len(links) > 0 {
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			// point them at the target instead
+			target, err := filepath.EvalSymlinks(p)
+			if err != nil {
+				return err
+			}
+			for _, l := range links {
+				if err := linkFile(target, l); err != nil {
+					return err
+				}
+			}
+		} else {
+			if err := os.Rename(p, links[0]); err != nil {
+				return err
+			}
+			for _, l := range links[1:] {
+				if err := linkFile(links[0], l); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// symlinksTo returns the paths of the symlinks (in the directory) to
+// the file p; for a symlink p, the ones that point at p itself.
+func (s *LocalZoneStore) symlinksTo(p string, fi os.FileInfo) []string {
+
+	files, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil
+	}
+
+	var links []string
+	for i := 0; i < len(files); i++ {
+		lp := filepath.Join(s.Dir, files[i].Name())
+		if files[i].Type()&fs.ModeSymlink == 0 || lp == p {
+			continue
+		}
+
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(lp)
+			if err != nil {
+				continue
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(s.Dir, target)
+			}
+			if filepath.Clean(target) == p {
+				links = append(links, lp)
+			}
+			continue
+		}
+
+		if lfi, err := os.Stat(lp); err == nil && os.SameFile(fi, lfi) {
+			links = append(links, lp)
+		}
+	}
+
+	return links
+}
+
+// FreeSpace returns the free space on the volume of Dir (statfs)
+// less the reserve; -1 on the platforms where it is not known.
+func (s *LocalZoneStore) FreeSpace(ctx context.Context) (int64, error) {
+	return s.freeSpace()
+}
+
+// Link makes newName a hardlink (or a symlink) of oldName.
+func (s *LocalZoneStore) Link(ctx context.Context, oldName string, newName string) error {
+	return linkFile(s.path(oldName), s.path(newName))
+}
+
+// linkZoneObject makes newName a copy of oldName; without
+// transferring the content, if the store supports it.
+func linkZoneObject(ctx context.Context, store ZoneStore, oldName string, newName string) error {
+
+	if l, ok := store.(ZoneLinker); ok {
+		return l.Link(ctx, oldName, newName)
+	}
+
+	r, err := store.Get(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return store.Put(ctx, newName, r)
+}
+
+// putSHA256 writes the digest of a zone file next to it (<name>.sha256)
+// in the format of sha256sum; so that it can be checked with sha256sum -c.
+func putSHA256(ctx context.Context, store ZoneStore, name string, digest string) error {
+	line := fmt.Sprintf("%s  %s\n", digest, filepath.Base(name))
+	return store.Put(ctx, name+sha256FileExt, strings.NewReader(line))
+}
+
+// getSHA256 reads the digest in the .sha256 file of a zone file.
+func getSHA256(ctx context.Context, store ZoneStore, name string) (string, error) {
+	r, err := store.Get(ctx, name+sha256FileExt)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(io.LimitReader(r, 1024))
+	if err != nil {
+		return "", err
+	}
+	digest, _, _ := strings.Cut(string(b), " ")
+
+	return strings.TrimSpace(digest), nil
+}
+
+// zoneStoreFor returns the store and the object name of a zone
+// file path. With the default (local) store, a path outside of
+// its directory is kept in its own directory; as before.
+func (c *CzdsAPI) zoneStoreFor(localFilePath string) (ZoneStore, string) {
+
+	name := filepath.Base(localFilePath)
+
+	if ls, ok := c.icann.ZoneStore.(*LocalZoneStore); ok {
+		if dir := filepath.Dir(localFilePath); filepath.Clean(ls.Dir) != filepath.Clean(dir) {
+			return ls.withDir(dir), name
+		}
+	}
+
+	return c.icann.ZoneStore, name
+}
@@ -0,0 +1,179 @@
+// (c) Kamiar Bahri
+package icannclient
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// verifyZoneFile checks a downloaded zone file before it is given its
+// final name: the size must be the Content-Length of the HEAD request
+// (if known), the gzip stream must be whole (trailer/CRC), and the zone
+// must start with the SOA record. The whole file is decompressed; but
+// nothing is kept in memory.
+func verifyZoneFile(filePath string, expectedLength uint64, tld string) error {
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if err := checkZoneLength(filePath, fi.Size(), expectedLength); err != nil {
+		return err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return verifyZoneStream(f, filePath, tld)
+}
+
+// checkZoneLength compares the size of a zone file with the
+// Content-Length of the HEAD request; if known.
+func checkZoneLength(name string, size int64, expectedLength uint64) error {
+	if expectedLength > 0 && uint64(size) != expectedLength {
+		return &IntegrityError{Path: name, Check: "length",
+			Err: fmt.Errorf("%d bytes received; expected %d", size, expectedLength)}
+	}
+	return nil
+}
+
+// verifyZoneStream does the gzip and SOA checks of verifyZoneFile
+// on a stream; name is used in the errors.
+func verifyZoneStream(r io.Reader, name string, tld string) error {
+
+	gz, err := gzip.NewReader(bufio.NewReaderSize(r, 64*1024))
+	if err != nil {
+		return &IntegrityError{Path: name, Check: "gzip", Err: err}
+	}
+	defer gz.Close()
+
+	// the first record; $ORIGIN/$TTL may come before it.
+	zr, err := NewZoneReader(gz, tld)
+	if err != nil {
+		return &IntegrityError{Path: name, Check: "gzip", Err: err}
+	}
+	rec, err := zr.Next()
+	if err != nil && err != io.EOF && isGzipError(err) {
+		return &IntegrityError{Path: name, Check: "gzip", Err: err}
+	}
+	if _, ok := rec.(*SOARecord); !ok {
+		if err == nil {
+			err = fmt.Errorf("the first record is %s", rec.Header().Type)
+		} else if err == io.EOF {
+			err = errors.New("the zone is empty")
+		}
+		return &IntegrityError{Path: name, Check: "soa", Err: err}
+	}
+
+	// the rest of the stream; the CRC and the size in the
+	// trailer are checked by the gzip reader at the end.
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return &IntegrityError{Path: name, Check: "gzip", Err: err}
+	}
+
+	return nil
+}
+
+// isGzipError returns true for the errors of a damaged
+// or truncated gzip stream.
+func isGzipError(err error) bool {
+	return errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// errVerifyAborted stops the checks of a verifyingReader
+// that is closed before the end of the stream.
+var errVerifyAborted = errors.New("zone file verification aborted")
+
+// verifyingReader passes a download through while verifyZoneStream
+// checks it (in a goroutine); io.EOF is only returned once all the
+// checks have passed; otherwise the error of the check is returned.
+// So that a ZoneStore does not create a zone file that fails a check.
+type verifyingReader struct {
+	r              io.Reader
+	pw             *io.PipeWriter
+	done           chan error
+	n              int64
+	name           string
+	expectedLength uint64
+
+	finished bool
+	err      error
+}
+
+func newVerifyingReader(r io.Reader, name string, tld string, expectedLength uint64) *verifyingReader {
+
+	pr, pw := io.Pipe()
+	vr := &verifyingReader{r: r, pw: pw, done: make(chan error, 1), name: name, expectedLength: expectedLength}
+
+	go func() {
+		err := verifyZoneStream(pr, name, tld)
+		if err == nil {
+			// (bytes after the gzip stream; if any)
+			_, err = io.Copy(io.Discard, pr)
+		}
+		if err != nil {
+			pr.CloseWithError(err)
+		}
+		vr.done <- err
+	}()
+
+	return vr
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+
+	n, err := vr.r.Read(p)
+	vr.n += int64(n)
+
+	if n > 0 {
+		// the checks have stopped (failed) before the end
+		if _, werr := vr.pw.Write(p[:n]); werr != nil {
+			if verr := vr.result(); verr != nil {
+				return n, verr
+			}
+			return n, werr
+		}
+	}
+
+	switch {
+	case err == io.EOF:
+		vr.pw.Close()
+		if verr := vr.result(); verr != nil {
+			return n, verr
+		}
+		if lerr := checkZoneLength(vr.name, vr.n, vr.expectedLength); lerr != nil {
+			return n, lerr
+		}
+	case err != nil:
+		vr.pw.CloseWithError(err)
+		vr.result()
+	}
+
+	return n, err
+}
+
+// Close stops the checks if the stream was not read to the end (e.g.
+// the ZoneStore gave up); so that the goroutine of the checks ends.
+func (vr *verifyingReader) Close() error {
+	vr.pw.CloseWithError(errVerifyAborted)
+	vr.result()
+
+	return nil
+}
+
+// result waits for the checks to finish; and returns their result.
+func (vr *verifyingReader) result() error {
+	if !vr.finished {
+		vr.err = <-vr.done
+		vr.finished = true
+	}
+	return vr.err
+}